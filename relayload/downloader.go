@@ -4,40 +4,73 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
+type DownloaderConfig struct {
+	timeout  time.Duration
+	profiles RateProfiles
+	authFunc SetAuthFunc
+}
+
 // Downloader struct
 type Downloader struct {
-	timeout time.Duration
-	request *http.Request
+	timeout  time.Duration
+	profiles RateProfiles
+	request  *http.Request
+	workers  sync.WaitGroup
 }
 
-func NewDownloader(timeout time.Duration, authFunc SetAuthFunc) *Downloader {
+func NewDownloader(config *DownloaderConfig) *Downloader {
 	req, _ := http.NewRequest("GET", "", nil)
-	authFunc(req)
+	config.authFunc(req)
 	d := &Downloader{
-		timeout: timeout,
-		request: req,
+		timeout:  config.timeout,
+		profiles: config.profiles,
+		request:  req,
 	}
 	return d
 }
 
-func (d *Downloader) RunWorkers(ctx context.Context, numWorkers uint, tasks <-chan *Task, limiter <-chan struct{}, results chan<- *Result) {
+// RunWorkers spawns the download workers, each emulating a client with its own read rate.
+// Workers stop taking new tasks once ctx is done, in-flight downloads are aborted once downloadCtx is done.
+func (d *Downloader) RunWorkers(ctx context.Context, downloadCtx context.Context, numWorkers uint, tasks <-chan *Task, limiter <-chan struct{}, results chan<- *Result) {
+	assigned := make(map[*RateProfile]int)
 	for i := uint(0); i < numWorkers; i++ {
-		go d.run(ctx, tasks, limiter, results)
+		profile := d.profiles.Assign(i, numWorkers)
+		assigned[profile]++
+		d.workers.Add(1)
+		go d.run(ctx, downloadCtx, profile.Bitrate, tasks, limiter, results)
+	}
+	if len(d.profiles) > 0 {
+		for _, profile := range d.profiles {
+			log.Printf("%d workers reading at %s\n", assigned[profile], profile)
+		}
 	}
 }
 
-func (d *Downloader) run(ctx context.Context, tasks <-chan *Task, limiter <-chan struct{}, results chan<- *Result) {
+// Wait waits for all workers to finish their last download
+func (d *Downloader) Wait() {
+	d.workers.Wait()
+}
+
+func (d *Downloader) run(ctx context.Context, downloadCtx context.Context, bitrate int64, tasks <-chan *Task, limiter <-chan struct{}, results chan<- *Result) {
+	defer d.workers.Done()
 	client := &http.Client{
 		Timeout: d.timeout,
 		// Transport: transport,
 	}
 
 	// fetch first task
-	task := <-tasks
+	var task *Task
+	select {
+	case <-ctx.Done():
+		return
+	case task = <-tasks:
+	}
 	for {
 		// limit download
 		select {
@@ -45,7 +78,11 @@ func (d *Downloader) run(ctx context.Context, tasks <-chan *Task, limiter <-chan
 			return
 		case <-limiter:
 		}
-		result := d.process(client, task)
+		// the limiter is closed without a limit, so the select may pick it after the end of the run
+		if ctx.Err() != nil {
+			return
+		}
+		result := d.process(downloadCtx, client, task, bitrate)
 		results <- result
 
 		// fetch new task or reuse previous (playlist too short)
@@ -58,18 +95,20 @@ func (d *Downloader) run(ctx context.Context, tasks <-chan *Task, limiter <-chan
 	}
 }
 
-func (d *Downloader) process(client *http.Client, task *Task) *Result {
-	result := &Result{}
+// process downloads a task, reading the response body at most at bitrate bits per second
+func (d *Downloader) process(ctx context.Context, client *http.Client, task *Task, bitrate int64) *Result {
+	result := &Result{Stream: task.Stream}
 	// req, err := http.NewRequest("GET", task.URL, nil)
-	req := cloneRequest(d.request)
+	req := cloneRequest(d.request).WithContext(ctx)
 	req.URL = task.URL
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err == nil {
-		result.Size = resp.ContentLength
 		result.Code = resp.StatusCode
-		io.Copy(ioutil.Discard, resp.Body)
+		result.Size, err = io.Copy(ioutil.Discard, newThrottledReader(resp.Body, bitrate))
 		resp.Body.Close()
 	}
+	result.Duration = time.Since(start)
 	result.Err = err
 	return result
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -14,11 +16,50 @@ func BenchmarkProcess(b *testing.B) {
 	}))
 	defer server.Close()
 
-	d := NewDownloader(time.Second)
+	d := NewDownloader(&DownloaderConfig{
+		timeout:  time.Second,
+		authFunc: func(*http.Request) {},
+	})
 
 	client := server.Client()
-	task := &Task{}
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	task := &Task{URL: u}
 	for i := 0; i < b.N; i++ {
-		d.process(client, task)
+		d.process(context.Background(), client, task, 0)
+	}
+}
+
+func TestDownloader_RunWorkers_stopped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write(make([]byte, 100))
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDownloader(&DownloaderConfig{
+		timeout:  time.Second,
+		authFunc: func(*http.Request) {},
+	})
+	const workers = 50
+	tasks := make(chan *Task, workers)
+	for i := 0; i < workers; i++ {
+		tasks <- &Task{URL: u}
+	}
+	// without a limit the limiter is closed and always ready
+	limiter := make(chan struct{})
+	close(limiter)
+	results := make(chan *Result, workers)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.RunWorkers(ctx, context.Background(), workers, tasks, limiter, results)
+	d.Wait()
+	if len(results) != 0 {
+		t.Errorf("RunWorkers() started %d downloads after the end of the run, expected none", len(results))
 	}
 }
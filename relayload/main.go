@@ -25,15 +25,55 @@ func main() {
 	var auth = flag.String("auth", "", "auth type (basic)")
 	var user = flag.String("user", "", "auth username")
 	var password = flag.String("password", "", "auth password")
+	var formatWeights = flag.String("format-weights", "", "client weights per stream format, e.g. hls=3,dash=1,progressive=1")
+	var connections = flag.Uint("connections", 10, "number of long-lived connections to progressive streams")
+	var bitrate = flag.Int64("bitrate", 0, "read rate of progressive connections in kbit/s, 0 reads as fast as possible")
+	var stallTimeout = flag.Duration("stall-timeout", time.Second*5, "progressive connections without data for this long are considered stalled")
+	var rateProfiles = flag.String("rate-profiles", "", "client read rate distribution, e.g. broadband=70,4g=20,3g=10 or 2000=1 for a fixed rate in kbit/s")
+	var timeout = flag.Duration("timeout", 0, "segment request timeout, defaults to the segment duration")
+	var streamFile = flag.String("streams", "", "file with one stream URL[#weight] per line, reloaded on SIGHUP")
+	var shutdownTimeout = flag.Duration("shutdown-timeout", time.Second*10, "max time to wait for in-flight downloads on shutdown")
+	var duration = flag.Duration("duration", 0, "stop after this duration, 0 runs until interrupted")
+	var maxRequests = flag.Uint64("requests", 0, "stop after this many segment requests, 0 for no limit")
+	var maxErrorRate = flag.Float64("max-error-rate", -1, "fail if more than this percentage of requests fail, -1 to disable")
+	var maxP99 = flag.Duration("max-p99", 0, "fail if the 99th percentile segment latency is above this, 0 to disable")
+	var minRate = flag.Float64("min-rate", 0, "fail if the average segment throughput in Mbit/s is below this, 0 to disable")
+	var maxStalls = flag.Int64("max-stalls", -1, "fail if progressive connections stall more often than this, -1 to disable")
 	flag.Parse()
-	urls := flag.Args()
-	log.Printf("Fetching from %d playlist\n", len(urls))
+
+	fw, err := ParseFormatWeights(*formatWeights)
+	if err != nil {
+		log.Fatal(err)
+	}
+	profiles, err := ParseRateProfiles(*rateProfiles)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *timeout == 0 {
+		*timeout = *segmentDuration
+	}
+	scenarioConfig := &ScenarioConfig{
+		args:          flag.Args(),
+		file:          *streamFile,
+		formatWeights: fw,
+		factor:        *factor,
+		connections:   *connections,
+	}
+	sc, err := LoadScenario(scenarioConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sc.Log()
+	var scenario atomic.Value
+	scenario.Store(sc)
 
 	tasks := make(chan *Task, 50)
 	limiter := make(chan struct{}, *numWorkers)
 	results := make(chan *Result, ResultQueueLength)
 	iteration := make(chan struct{})
 	ctx, cancel := context.WithCancel(context.Background())
+	downloadCtx, cancelDownloads := context.WithCancel(context.Background())
+	defer cancelDownloads()
 	var lastLimit atomic.Value
 
 	var authFunc SetAuthFunc
@@ -46,62 +86,59 @@ func main() {
 	}
 
 	// Source routine
+	pl := NewPlaylistLoader(&LoaderConfig{
+		sample:   *sample,
+		taskChan: tasks,
+		interval: *segmentDuration,
+		authFunc: authFunc,
+	})
 	go func() {
-		loaderConfig := &LoaderConfig{
-			sample:   *sample,
-			factor:   *factor,
-			taskChan: tasks,
-			interval: *segmentDuration,
-			authFunc: authFunc,
-		}
-		pl := NewPlaylistLoader(loaderConfig)
 		for {
-			for _, URL := range urls {
-				err := pl.Load(ctx, URL)
+			segmented := scenario.Load().(*Scenario).Segmented
+			for _, stream := range segmented {
+				err := pl.Load(ctx, stream)
 				if err != nil && !strings.HasSuffix(err.Error(), "context canceled") {
 					log.Println(err)
 				}
 			}
+			wait := time.Millisecond * 20
+			if len(segmented) == 0 {
+				// no playlist loads to pace the stats with only progressive streams
+				wait = *segmentDuration
+			}
 			select {
 			case <-ctx.Done():
 				return
-			default:
-				time.Sleep(time.Millisecond * 20)
-				iteration <- struct{}{}
+			case <-time.After(wait):
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case iteration <- struct{}{}:
 			}
 		}
 	}()
 
-	// Stats routine
+	// Stats routine, runs until all results are collected
+	stats := NewStats()
+	statsDone := make(chan struct{})
+	requestsDone := make(chan struct{})
 	go func() {
-		hits, errors, fails := uint64(0), uint64(0), uint64(0)
-		last := time.Now()
-		bytes := int64(0)
+		defer close(statsDone)
+		limitReached := false
 		for {
 			select {
-			case <-ctx.Done():
-				return
 			case <-iteration:
-				now := time.Now()
-				timeFactor := float64(now.Sub(last)) / float64(time.Second)
-				last = now
-				bits := float64(bytes) / 1048576 * 8 / timeFactor
-				ops := float64(hits) / timeFactor
-				log.Printf("success: %d, errors: %d, fails: %d, rate: %0.2f Mbit/s, ops: %0.2f Req/s",
-					hits, errors, fails, bits, ops)
+				hits := stats.Log(scenario.Load().(*Scenario))
 				lastLimit.Store(uint32(hits))
-				hits, errors, fails = 0, 0, 0
-				bytes = 0
-			case res := <-results:
-				bytes += res.Size
-				if res.Err != nil {
-					fails++
-				} else {
-					if res.Code == 200 {
-						hits++
-					} else {
-						errors++
-					}
+			case res, ok := <-results:
+				if !ok {
+					return
+				}
+				stats.Add(res)
+				if *maxRequests > 0 && !limitReached && stats.Requests() >= *maxRequests {
+					limitReached = true
+					close(requestsDone)
 				}
 			}
 		}
@@ -115,7 +152,10 @@ func main() {
 			return
 		} else if *limit == -1 {
 			auto = true
-			*limit = int64(len(urls) * 50 / int(*sample))
+			*limit = int64(len(sc.Segmented) * 50 / int(*sample))
+			if *limit < 1 {
+				*limit = 1
+			}
 		}
 		lastLimit.Store(uint32(*limit))
 		ticker := time.NewTicker(time.Second / time.Duration(*limit))
@@ -140,8 +180,24 @@ func main() {
 	}()
 
 	// Spawn workers
-	d := NewDownloader(*segmentDuration, authFunc)
-	d.RunWorkers(ctx, *numWorkers, tasks, limiter, results)
+	d := NewDownloader(&DownloaderConfig{
+		timeout:  *timeout,
+		profiles: profiles,
+		authFunc: authFunc,
+	})
+	d.RunWorkers(ctx, downloadCtx, *numWorkers, tasks, limiter, results)
+
+	// Open progressive connections
+	pc := NewProgressiveClient(&ProgressiveConfig{
+		bitrate:      *bitrate * 1000,
+		stallTimeout: *stallTimeout,
+		profiles:     profiles,
+		authFunc:     authFunc,
+	})
+	progressiveCtx, cancelProgressive := context.WithCancel(ctx)
+	for _, stream := range sc.Progressive {
+		pc.RunConnections(progressiveCtx, stream)
+	}
 
 	// signal handling
 	c := make(chan os.Signal, 1)
@@ -150,14 +206,86 @@ func main() {
 		syscall.SIGINT,
 		syscall.SIGTERM)
 
-	for {
-		sig := <-c
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
+
+	running := true
+	for running {
+		var sig os.Signal
+		select {
+		case <-deadline:
+			log.Println("Duration reached")
+			running = false
+			continue
+		case <-requestsDone:
+			log.Println("Request limit reached")
+			running = false
+			continue
+		case sig = <-c:
+		}
 		log.Println("Caught signal", sig)
-		if sig != syscall.SIGHUP {
-			// Wait for shutdown
-			cancel()
-			time.Sleep(200 * time.Millisecond)
-			return
+		if sig == syscall.SIGHUP {
+			// Reload streams
+			sc, err := LoadScenario(scenarioConfig)
+			if err != nil {
+				log.Println("reload failed:", err)
+				continue
+			}
+			sc.Log()
+			scenario.Store(sc)
+			cancelProgressive()
+			progressiveCtx, cancelProgressive = context.WithCancel(ctx)
+			for _, stream := range sc.Progressive {
+				pc.RunConnections(progressiveCtx, stream)
+			}
+			continue
 		}
+		running = false
+	}
+
+	// Stop scheduling and wait for in-flight downloads
+	cancel()
+	log.Printf("Waiting up to %v for in-flight downloads\n", *shutdownTimeout)
+	workersDone := make(chan struct{})
+	go func() {
+		d.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-time.After(*shutdownTimeout):
+		log.Println("Shutdown timeout, aborting downloads")
+		cancelDownloads()
+		<-workersDone
+	case sig := <-c:
+		log.Println("Caught signal", sig, "aborting downloads")
+		cancelDownloads()
+		<-workersDone
+	}
+	cancelProgressive()
+
+	// Collect remaining results
+	close(results)
+	<-statsDone
+	stats.SetPlaylists(pl.Counts())
+	summary := stats.Summary(scenario.Load().(*Scenario))
+	summary.Log()
+
+	// Check pass/fail thresholds
+	thresholds := &Thresholds{
+		MaxErrorRate: *maxErrorRate / 100,
+		MaxP99:       *maxP99,
+		MinRate:      *minRate,
+		MaxStalls:    *maxStalls,
+	}
+	violations := thresholds.Check(summary)
+	for _, violation := range violations {
+		log.Println("FAIL:", violation)
+	}
+	if len(violations) > 0 {
+		cancelDownloads()
+		os.Exit(1)
 	}
 }
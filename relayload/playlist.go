@@ -5,12 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/quangngotan95/go-m3u8/m3u8"
@@ -21,7 +21,6 @@ type SetAuthFunc func(*http.Request)
 
 type LoaderConfig struct {
 	sample   uint
-	factor   uint
 	taskChan chan<- *Task
 	interval time.Duration
 	authFunc SetAuthFunc
@@ -29,8 +28,9 @@ type LoaderConfig struct {
 
 // PlaylistLoader for downloading/parsing segmented http live playlists
 type PlaylistLoader struct {
+	loads    uint64 // completed playlist loads, accessed atomically
+	fails    uint64 // failed playlist loads, accessed atomically
 	sample   uint
-	factor   uint
 	taskChan chan<- *Task
 	interval time.Duration
 	client   *http.Client
@@ -41,7 +41,6 @@ type PlaylistLoader struct {
 func NewPlaylistLoader(config *LoaderConfig) *PlaylistLoader {
 	return &PlaylistLoader{
 		sample:   config.sample,
-		factor:   config.factor,
 		taskChan: config.taskChan,
 		interval: config.interval,
 		setAuth:  config.authFunc,
@@ -52,19 +51,28 @@ func NewPlaylistLoader(config *LoaderConfig) *PlaylistLoader {
 	}
 }
 
-// Load loads a playlist and creates Tasks for segment entries
-func (pl *PlaylistLoader) Load(parent context.Context, urlString string) error {
+// Load loads a stream playlist and creates Tasks for segment entries
+func (pl *PlaylistLoader) Load(parent context.Context, stream *Stream) error {
 	deadline := time.Now().Add(pl.interval)
 	ctx, cancel := context.WithDeadline(parent, deadline)
 	defer cancel()
-	playlistURL, err := url.Parse(urlString)
-	if err != nil {
-		return err
+	err := pl.get(ctx, stream, stream.URL)
+	if parent.Err() == nil {
+		// loads interrupted by the end of the run don't count
+		atomic.AddUint64(&pl.loads, 1)
+		if err != nil {
+			atomic.AddUint64(&pl.fails, 1)
+		}
 	}
-	return pl.get(ctx, playlistURL)
+	return err
 }
 
-func (pl *PlaylistLoader) get(ctx context.Context, playlistURL *url.URL) error {
+// Counts returns the number of playlist loads and how many of them failed
+func (pl *PlaylistLoader) Counts() (loads uint64, fails uint64) {
+	return atomic.LoadUint64(&pl.loads), atomic.LoadUint64(&pl.fails)
+}
+
+func (pl *PlaylistLoader) get(ctx context.Context, stream *Stream, playlistURL *url.URL) error {
 	req, err := http.NewRequestWithContext(ctx, "GET", playlistURL.String(), nil)
 	if err != nil {
 		return err
@@ -76,16 +84,14 @@ func (pl *PlaylistLoader) get(ctx context.Context, playlistURL *url.URL) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		log.Printf("Playlist %s: got %s\n", playlistURL.String(), resp.Status)
-		return nil
+		return fmt.Errorf("Playlist %s: got %s", playlistURL.String(), resp.Status)
 	}
-	defer resp.Body.Close()
 
 	switch path.Ext(playlistURL.Path) {
 	case ".mpd":
-		return pl.parseMpd(ctx, resp.Body, playlistURL)
+		return pl.parseMpd(ctx, stream, resp.Body, playlistURL)
 	case ".m3u8":
-		return pl.parseM3u8(ctx, resp.Body, playlistURL)
+		return pl.parseM3u8(ctx, stream, resp.Body, playlistURL)
 	default:
 		return fmt.Errorf("Unknown playlist format: '%v' for %v", path.Ext(playlistURL.Path), playlistURL.String())
 	}
@@ -97,8 +103,10 @@ var (
 	errTimescaleMissing         = errors.New("Dash Manifest SegmentTemplate is missing timescale")
 )
 
+// queue sends a task once for every client of the task's stream
 func (pl *PlaylistLoader) queue(ctx context.Context, task *Task) error {
-	for i := uint(0); i < pl.factor; i++ {
+	clients := task.Stream.nextClients()
+	for i := uint(0); i < clients; i++ {
 		select {
 		case <-ctx.Done():
 			return nil
@@ -109,7 +117,7 @@ func (pl *PlaylistLoader) queue(ctx context.Context, task *Task) error {
 }
 
 // parseMpd parses DASH manifest and queues the segment download tasks
-func (pl *PlaylistLoader) parseMpd(ctx context.Context, reader io.Reader, playlistURL *url.URL) error {
+func (pl *PlaylistLoader) parseMpd(ctx context.Context, stream *Stream, reader io.Reader, playlistURL *url.URL) error {
 	manifest, err := mpd.Read(reader)
 	if err != nil {
 		return err
@@ -167,7 +175,7 @@ func (pl *PlaylistLoader) parseMpd(ctx context.Context, reader io.Reader, playli
 						if err != nil {
 							return err
 						}
-						err = pl.queue(ctx, &Task{URL: segmentURL})
+						err = pl.queue(ctx, &Task{URL: segmentURL, Stream: stream})
 						if err != nil {
 							return err
 						}
@@ -207,7 +215,7 @@ func (pl *PlaylistLoader) getSubURL(playlistURL *url.URL, subURI string) (subURL
 
 // parseMpd parses m3u8 playlists and creates download tasks for all segments.
 // Can work with multi-quality master-playlists.
-func (pl *PlaylistLoader) parseM3u8(ctx context.Context, reader io.Reader, playlistURL *url.URL) error {
+func (pl *PlaylistLoader) parseM3u8(ctx context.Context, stream *Stream, reader io.Reader, playlistURL *url.URL) error {
 	playlist, err := m3u8.Read(reader)
 	if err != nil {
 		return fmt.Errorf("playlist %v error: %v", playlistURL, err)
@@ -221,7 +229,7 @@ func (pl *PlaylistLoader) parseM3u8(ctx context.Context, reader io.Reader, playl
 				if err != nil {
 					return err
 				}
-				err = pl.get(ctx, subURL)
+				err = pl.get(ctx, stream, subURL)
 				if err != nil {
 					return err
 				}
@@ -239,7 +247,7 @@ func (pl *PlaylistLoader) parseM3u8(ctx context.Context, reader io.Reader, playl
 					if err != nil {
 						return err
 					}
-					err = pl.queue(ctx, &Task{URL: segmentURL})
+					err = pl.queue(ctx, &Task{URL: segmentURL, Stream: stream})
 					if err != nil {
 						return err
 					}
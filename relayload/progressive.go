@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// progressiveStats counts the activity of long-lived connections to a stream.
+// Updated atomically by the connections and reset by the stats routine.
+type progressiveStats struct {
+	connections int64
+	bytes       int64
+	stalls      uint64
+	fails       uint64
+}
+
+// swap returns the current counters and resets the interval counters
+func (ps *progressiveStats) swap() (connections int64, bytes int64, stalls uint64, fails uint64) {
+	connections = atomic.LoadInt64(&ps.connections)
+	bytes = atomic.SwapInt64(&ps.bytes, 0)
+	stalls = atomic.SwapUint64(&ps.stalls, 0)
+	fails = atomic.SwapUint64(&ps.fails, 0)
+	return
+}
+
+type ProgressiveConfig struct {
+	bitrate      int64
+	stallTimeout time.Duration
+	profiles     RateProfiles
+	authFunc     SetAuthFunc
+}
+
+// ProgressiveClient keeps long-lived connections open to progressive http streams
+type ProgressiveClient struct {
+	bitrate      int64
+	stallTimeout time.Duration
+	profiles     RateProfiles
+	setAuth      SetAuthFunc
+	client       *http.Client
+}
+
+var errStalled = errors.New("stream stalled")
+
+// NewProgressiveClient creates a new progressive stream client
+func NewProgressiveClient(config *ProgressiveConfig) *ProgressiveClient {
+	return &ProgressiveClient{
+		bitrate:      config.bitrate,
+		stallTimeout: config.stallTimeout,
+		profiles:     config.profiles,
+		setAuth:      config.authFunc,
+		// no timeout, connections are kept open until they fail or stall
+		client: &http.Client{},
+	}
+}
+
+// RunConnections opens the stream's assigned connections and reconnects them on failure
+func (pc *ProgressiveClient) RunConnections(ctx context.Context, stream *Stream) {
+	for i := uint(0); i < stream.connections; i++ {
+		profile := pc.profiles.Assign(i, stream.connections)
+		go pc.run(ctx, stream, profile.Bitrate)
+	}
+}
+
+func (pc *ProgressiveClient) run(ctx context.Context, stream *Stream, clientBitrate int64) {
+	for {
+		err := pc.stream(ctx, stream, clientBitrate)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if err == errStalled {
+			atomic.AddUint64(&stream.progressive.stalls, 1)
+		} else {
+			atomic.AddUint64(&stream.progressive.fails, 1)
+		}
+		log.Printf("Stream %s: %v\n", stream, err)
+
+		// back off before reconnecting
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// stream reads from a single connection until it fails or stalls.
+// The connection is read at the stream bitrate, limited by the client bitrate.
+func (pc *ProgressiveClient) stream(parent context.Context, stream *Stream, clientBitrate int64) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", stream.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	pc.setAuth(req)
+
+	// cancel the request if no data arrives within the stall timeout
+	var stalled int32
+	watchdog := time.AfterFunc(pc.stallTimeout, func() {
+		atomic.StoreInt32(&stalled, 1)
+		cancel()
+	})
+	defer watchdog.Stop()
+
+	resp, err := pc.client.Do(req)
+	if err != nil {
+		if atomic.LoadInt32(&stalled) == 1 {
+			return errStalled
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got %s", resp.Status)
+	}
+
+	atomic.AddInt64(&stream.progressive.connections, 1)
+	defer atomic.AddInt64(&stream.progressive.connections, -1)
+
+	reader := newThrottledReader(newThrottledReader(resp.Body, clientBitrate), pc.bitrate)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			watchdog.Reset(pc.stallTimeout)
+			atomic.AddInt64(&stream.progressive.bytes, int64(n))
+		}
+		if err != nil {
+			if atomic.LoadInt32(&stalled) == 1 {
+				return errStalled
+			}
+			return err
+		}
+	}
+}
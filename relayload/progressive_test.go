@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProgressiveClient_stall(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write(make([]byte, 4000))
+		resp.(http.Flusher).Flush()
+		// stop sending data
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	stream, err := ParseStream(server.URL + "/s1.webm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := NewProgressiveClient(&ProgressiveConfig{
+		stallTimeout: 100 * time.Millisecond,
+		authFunc:     func(*http.Request) {},
+	})
+
+	err = pc.stream(context.Background(), stream, 0)
+	if err != errStalled {
+		t.Errorf("ProgressiveClient.stream() got = %v, expected %v", err, errStalled)
+	}
+	if _, bytes, _, _ := stream.progressive.swap(); bytes != 4000 {
+		t.Errorf("ProgressiveClient.stream() read %d bytes, expected 4000", bytes)
+	}
+}
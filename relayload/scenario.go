@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type ScenarioConfig struct {
+	args          []string
+	file          string
+	formatWeights map[Format]float64
+	factor        uint
+	connections   uint
+}
+
+// Scenario is the set of streams the clients are distributed on
+type Scenario struct {
+	Segmented   []*Stream
+	Progressive []*Stream
+}
+
+// LoadScenario parses the streams given on the command line and in the stream file.
+// Streams are given as URL[#weight], one per line in the stream file.
+func LoadScenario(config *ScenarioConfig) (*Scenario, error) {
+	args := append([]string(nil), config.args...)
+	if config.file != "" {
+		lines, err := readStreamFile(config.file)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, lines...)
+	}
+
+	var streams []*Stream
+	for _, arg := range args {
+		stream, err := ParseStream(arg)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, stream)
+	}
+	Distribute(streams, config.formatWeights, config.factor, config.connections)
+
+	sc := &Scenario{}
+	for _, stream := range streams {
+		if stream.Segmented() {
+			sc.Segmented = append(sc.Segmented, stream)
+		} else {
+			sc.Progressive = append(sc.Progressive, stream)
+		}
+	}
+	return sc, nil
+}
+
+// readStreamFile returns the stream lines of a file, skipping empty lines and comments
+func readStreamFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("stream file %v: %v", filename, err)
+	}
+	return lines, nil
+}
+
+// Log logs the share of clients per stream
+func (sc *Scenario) Log() {
+	for _, stream := range sc.Segmented {
+		log.Printf("%s: %0.1f%% of clients\n", stream, stream.Share(sc.Segmented)*100)
+	}
+	for _, stream := range sc.Progressive {
+		log.Printf("%s: %d connections\n", stream, stream.connections)
+	}
+	log.Printf("Fetching from %d playlist and %d progressive streams\n", len(sc.Segmented), len(sc.Progressive))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RateProfile is a class of clients reading at a limited bitrate
+type RateProfile struct {
+	Name    string
+	Bitrate int64 // bits per second, 0 for unlimited
+	Weight  float64
+}
+
+// namedRates contains typical downstream bitrates of viewers in bits per second
+var namedRates = map[string]int64{
+	"unlimited": 0,
+	"broadband": 25000000,
+	"dsl":       6000000,
+	"4g":        8000000,
+	"3g":        1500000,
+	"edge":      200000,
+}
+
+// RateProfiles is a distribution of client read rates
+type RateProfiles []*RateProfile
+
+// ParseRateProfiles parses a distribution like "broadband=70,4g=20,3g=10".
+// Rates are either one of the named rates or a bitrate in kbit/s.
+func ParseRateProfiles(list string) (RateProfiles, error) {
+	var profiles RateProfiles
+	if list == "" {
+		return profiles, nil
+	}
+	for _, entry := range strings.Split(list, ",") {
+		split := strings.Split(entry, "=")
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid rate profile '%v'", entry)
+		}
+		name := strings.TrimSpace(split[0])
+		bitrate, ok := namedRates[name]
+		if !ok {
+			kbit, err := strconv.ParseInt(name, 10, 64)
+			if err != nil || kbit < 0 {
+				return nil, fmt.Errorf("unknown rate '%v'", name)
+			}
+			bitrate = kbit * 1000
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(split[1]), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid rate profile weight '%v'", entry)
+		}
+		profiles = append(profiles, &RateProfile{
+			Name:    name,
+			Bitrate: bitrate,
+			Weight:  weight,
+		})
+	}
+	return profiles, nil
+}
+
+// Assign returns the profile of client i out of n clients.
+// Clients are assigned in proportion to the profile weights.
+func (rp RateProfiles) Assign(i uint, n uint) *RateProfile {
+	total := float64(0)
+	for _, p := range rp {
+		total += p.Weight
+	}
+	if total == 0 || n == 0 {
+		return &RateProfile{Name: "unlimited"}
+	}
+	// position of the client within the distribution
+	pos := (float64(i) + 0.5) / float64(n) * total
+	for _, p := range rp {
+		if pos < p.Weight {
+			return p
+		}
+		pos -= p.Weight
+	}
+	return rp[len(rp)-1]
+}
+
+// String returns a readable description of the profile
+func (p *RateProfile) String() string {
+	if p.Bitrate == 0 {
+		return p.Name
+	}
+	return fmt.Sprintf("%s (%0.1f Mbit/s)", p.Name, float64(p.Bitrate)/1000000)
+}
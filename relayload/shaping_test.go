@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRateProfiles_Assign(t *testing.T) {
+	profiles, err := ParseRateProfiles("broadband=70,4g=20,3g=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assigned := make(map[string]int)
+	for i := uint(0); i < 50; i++ {
+		assigned[profiles.Assign(i, 50).Name]++
+	}
+	expected := map[string]int{"broadband": 35, "4g": 10, "3g": 5}
+	for name, count := range expected {
+		if assigned[name] != count {
+			t.Errorf("RateProfiles.Assign() got = %d %s clients, expected %d", assigned[name], name, count)
+		}
+	}
+
+	fixed, err := ParseRateProfiles("2000=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := fixed.Assign(3, 10); p.Bitrate != 2000000 {
+		t.Errorf("RateProfiles.Assign() got = %d bit/s, expected 2000000", p.Bitrate)
+	}
+
+	if _, err := ParseRateProfiles("dialup=10"); err == nil {
+		t.Errorf("ParseRateProfiles() expected error for unknown rate")
+	}
+}
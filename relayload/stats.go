@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// counters accumulates results over a stats interval
+type counters struct {
+	hits   uint64
+	errors uint64
+	fails  uint64
+	bytes  int64
+}
+
+func (c *counters) add(res *Result) {
+	c.bytes += res.Size
+	if res.Err != nil {
+		c.fails++
+	} else {
+		if res.Code == 200 {
+			c.hits++
+		} else {
+			c.errors++
+		}
+	}
+}
+
+// rates returns the throughput in Mbit/s and the successful requests per second
+func (c *counters) rates(seconds float64) (bits float64, ops float64) {
+	bits = float64(c.bytes) / 1048576 * 8 / seconds
+	ops = float64(c.hits) / seconds
+	return
+}
+
+func (c *counters) reset() {
+	*c = counters{}
+}
+
+// progressiveTotals accumulates progressive connection stats over the whole run
+type progressiveTotals struct {
+	bytes  int64
+	stalls uint64
+	fails  uint64
+}
+
+// histogramMax is the largest duration tracked with millisecond resolution
+const histogramMax = 60 * time.Second
+
+// histogram records request durations with millisecond resolution
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     time.Duration
+	max     time.Duration
+}
+
+func newHistogram() *histogram {
+	return &histogram{
+		// the last bucket collects everything above histogramMax
+		buckets: make([]uint64, histogramMax/time.Millisecond+1),
+	}
+}
+
+func (h *histogram) add(d time.Duration) {
+	i := int(d / time.Millisecond)
+	if i >= len(h.buckets) {
+		i = len(h.buckets) - 1
+	}
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// percentile returns the upper bound of the bucket containing the p-th percentile,
+// capped at the largest recorded duration
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(p / 100 * float64(h.count))
+	if rank >= h.count {
+		rank = h.count - 1
+	}
+	seen := uint64(0)
+	for i, n := range h.buckets {
+		seen += n
+		if seen > rank {
+			upper := time.Duration(i+1) * time.Millisecond
+			if i == len(h.buckets)-1 || upper > h.max {
+				return h.max
+			}
+			return upper
+		}
+	}
+	return h.max
+}
+
+// Stats collects results for the interval log and the final run summary
+type Stats struct {
+	start time.Time
+	last  time.Time
+
+	interval          counters
+	intervalPerStream map[string]*counters
+
+	total          counters
+	totalPerStream map[string]*counters
+	progressive    map[string]*progressiveTotals
+	latency        *histogram
+	errorKinds     map[string]uint64
+	playlists      uint64
+	playlistFails  uint64
+}
+
+// NewStats creates a new result collector
+func NewStats() *Stats {
+	now := time.Now()
+	return &Stats{
+		start:             now,
+		last:              now,
+		intervalPerStream: make(map[string]*counters),
+		totalPerStream:    make(map[string]*counters),
+		progressive:       make(map[string]*progressiveTotals),
+		latency:           newHistogram(),
+		errorKinds:        make(map[string]uint64),
+	}
+}
+
+// Add records a download result
+func (s *Stats) Add(res *Result) {
+	s.interval.add(res)
+	s.total.add(res)
+	if res.Stream != nil {
+		name := res.Stream.String()
+		if _, ok := s.intervalPerStream[name]; !ok {
+			s.intervalPerStream[name] = &counters{}
+			s.totalPerStream[name] = &counters{}
+		}
+		s.intervalPerStream[name].add(res)
+		s.totalPerStream[name].add(res)
+	}
+	if res.Err == nil && res.Code == 200 {
+		s.latency.add(res.Duration)
+	} else {
+		s.errorKinds[errorKind(res)]++
+	}
+}
+
+// SetPlaylists records the playlist loads of the run, failed loads count as errors in the summary
+func (s *Stats) SetPlaylists(loads uint64, fails uint64) {
+	s.playlists, s.playlistFails = loads, fails
+}
+
+// Requests returns the number of segment requests completed so far
+func (s *Stats) Requests() uint64 {
+	return s.total.hits + s.total.errors + s.total.fails
+}
+
+// Log logs the stats of the last interval and returns the number of successful requests
+func (s *Stats) Log(sc *Scenario) uint64 {
+	now := time.Now()
+	timeFactor := float64(now.Sub(s.last)) / float64(time.Second)
+	s.last = now
+
+	hits := s.interval.hits
+	bits, ops := s.interval.rates(timeFactor)
+	log.Printf("success: %d, errors: %d, fails: %d, rate: %0.2f Mbit/s, ops: %0.2f Req/s",
+		s.interval.hits, s.interval.errors, s.interval.fails, bits, ops)
+	s.interval.reset()
+	if len(sc.Segmented) > 1 {
+		for _, stream := range sc.Segmented {
+			c, ok := s.intervalPerStream[stream.String()]
+			if !ok {
+				c = &counters{}
+			}
+			bits, ops := c.rates(timeFactor)
+			log.Printf("  %s: success: %d, errors: %d, fails: %d, rate: %0.2f Mbit/s, ops: %0.2f Req/s",
+				stream, c.hits, c.errors, c.fails, bits, ops)
+		}
+	}
+	// also when not logged, so streams added on reload don't report the counts of earlier intervals
+	for _, c := range s.intervalPerStream {
+		c.reset()
+	}
+	if len(sc.Progressive) > 0 {
+		s.logProgressive(sc.Progressive, timeFactor)
+	}
+	return hits
+}
+
+// logProgressive logs the connection stats of progressive streams for the last interval
+func (s *Stats) logProgressive(streams []*Stream, seconds float64) {
+	var totalConnections, totalBytes int64
+	var totalStalls, totalFails uint64
+	for _, stream := range streams {
+		connections, bytes, stalls, fails := s.swapProgressive(stream)
+		totalConnections += connections
+		totalBytes += bytes
+		totalStalls += stalls
+		totalFails += fails
+		if len(streams) > 1 {
+			bits, perConnection := connectionRates(bytes, connections, seconds)
+			log.Printf("  %s: connections: %d, stalls: %d, fails: %d, rate: %0.2f Mbit/s, per connection: %0.2f Mbit/s",
+				stream, connections, stalls, fails, bits, perConnection)
+		}
+	}
+	bits, perConnection := connectionRates(totalBytes, totalConnections, seconds)
+	log.Printf("progressive connections: %d, stalls: %d, fails: %d, rate: %0.2f Mbit/s, per connection: %0.2f Mbit/s",
+		totalConnections, totalStalls, totalFails, bits, perConnection)
+}
+
+// swapProgressive resets the interval counters of a progressive stream and adds them to the run totals
+func (s *Stats) swapProgressive(stream *Stream) (connections int64, bytes int64, stalls uint64, fails uint64) {
+	connections, bytes, stalls, fails = stream.progressive.swap()
+	name := stream.String()
+	totals, ok := s.progressive[name]
+	if !ok {
+		totals = &progressiveTotals{}
+		s.progressive[name] = totals
+	}
+	totals.bytes += bytes
+	totals.stalls += stalls
+	totals.fails += fails
+	return
+}
+
+// connectionRates returns the total and the average per connection throughput in Mbit/s
+func connectionRates(bytes int64, connections int64, seconds float64) (bits float64, perConnection float64) {
+	bits = float64(bytes) / 1048576 * 8 / seconds
+	if connections > 0 {
+		perConnection = bits / float64(connections)
+	}
+	return
+}
+
+// errorKind classifies a failed result for the error breakdown
+func errorKind(res *Result) string {
+	if res.Err == nil {
+		return fmt.Sprintf("HTTP %d", res.Code)
+	}
+	err := res.Err
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	msg := err.Error()
+	for _, kind := range []string{"connection refused", "connection reset", "no such host", "unexpected EOF", "context canceled"} {
+		if strings.Contains(msg, kind) {
+			return kind
+		}
+	}
+	return msg
+}
+
+// Summary of a whole run
+type Summary struct {
+	Duration  time.Duration
+	Requests  uint64
+	Hits      uint64
+	Errors    uint64
+	Fails     uint64
+	Bytes     int64
+	Rate      float64 // Mbit/s
+	Ops       float64 // Req/s
+	ErrorRate float64 // fraction of segment requests and playlist loads that were not successful
+
+	Playlists     uint64 // playlist loads
+	PlaylistFails uint64
+
+	LatencyMean time.Duration
+	LatencyP50  time.Duration
+	LatencyP90  time.Duration
+	LatencyP99  time.Duration
+	LatencyMax  time.Duration
+
+	ErrorKinds map[string]uint64
+
+	ProgressiveBytes  int64
+	ProgressiveRate   float64 // Mbit/s
+	ProgressiveStalls uint64
+	ProgressiveFails  uint64
+
+	streams     []string
+	perStream   map[string]*counters
+	progressive map[string]*progressiveTotals
+}
+
+// Summary computes the summary of the run so far.
+// Pending progressive counters of the scenario's streams are included.
+func (s *Stats) Summary(sc *Scenario) *Summary {
+	for _, stream := range sc.Progressive {
+		s.swapProgressive(stream)
+	}
+	duration := time.Since(s.start)
+	seconds := float64(duration) / float64(time.Second)
+	sum := &Summary{
+		Duration:      duration,
+		Hits:          s.total.hits,
+		Errors:        s.total.errors,
+		Fails:         s.total.fails,
+		Requests:      s.total.hits + s.total.errors + s.total.fails,
+		Bytes:         s.total.bytes,
+		Playlists:     s.playlists,
+		PlaylistFails: s.playlistFails,
+		LatencyMean:   s.latency.mean(),
+		LatencyP50:    s.latency.percentile(50),
+		LatencyP90:    s.latency.percentile(90),
+		LatencyP99:    s.latency.percentile(99),
+		LatencyMax:    s.latency.max,
+		ErrorKinds:    s.errorKinds,
+		perStream:     s.totalPerStream,
+		progressive:   s.progressive,
+	}
+	sum.Rate, sum.Ops = s.total.rates(seconds)
+	if attempts := sum.Requests + sum.Playlists; attempts > 0 {
+		sum.ErrorRate = float64(sum.Errors+sum.Fails+sum.PlaylistFails) / float64(attempts)
+	}
+	for name, totals := range s.progressive {
+		sum.ProgressiveBytes += totals.bytes
+		sum.ProgressiveStalls += totals.stalls
+		sum.ProgressiveFails += totals.fails
+		sum.streams = append(sum.streams, name)
+	}
+	sum.ProgressiveRate, _ = connectionRates(sum.ProgressiveBytes, 0, seconds)
+	for name := range s.totalPerStream {
+		sum.streams = append(sum.streams, name)
+	}
+	sort.Strings(sum.streams)
+	return sum
+}
+
+// Log logs the run summary
+func (sum *Summary) Log() {
+	log.Printf("Run summary after %v", sum.Duration.Round(time.Second))
+	log.Printf("requests: %d, success: %d, errors: %d, fails: %d, error rate: %0.3f%%",
+		sum.Requests, sum.Hits, sum.Errors, sum.Fails, sum.ErrorRate*100)
+	if sum.Playlists > 0 {
+		log.Printf("playlist loads: %d, failed: %d", sum.Playlists, sum.PlaylistFails)
+	}
+	log.Printf("transferred: %0.2f MiB, rate: %0.2f Mbit/s, ops: %0.2f Req/s",
+		float64(sum.Bytes)/1048576, sum.Rate, sum.Ops)
+	log.Printf("latency: avg %v, p50 %v, p90 %v, p99 %v, max %v",
+		sum.LatencyMean.Round(time.Millisecond), sum.LatencyP50.Round(time.Millisecond),
+		sum.LatencyP90.Round(time.Millisecond), sum.LatencyP99.Round(time.Millisecond),
+		sum.LatencyMax.Round(time.Millisecond))
+	if len(sum.ErrorKinds) > 0 {
+		var kinds []string
+		for kind := range sum.ErrorKinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			log.Printf("  %s: %d", kind, sum.ErrorKinds[kind])
+		}
+	}
+	if len(sum.progressive) > 0 {
+		log.Printf("progressive transferred: %0.2f MiB, rate: %0.2f Mbit/s, stalls: %d, fails: %d",
+			float64(sum.ProgressiveBytes)/1048576, sum.ProgressiveRate, sum.ProgressiveStalls, sum.ProgressiveFails)
+	}
+	if len(sum.streams) > 1 {
+		for _, name := range sum.streams {
+			if c, ok := sum.perStream[name]; ok {
+				log.Printf("  %s: success: %d, errors: %d, fails: %d, transferred: %0.2f MiB",
+					name, c.hits, c.errors, c.fails, float64(c.bytes)/1048576)
+			}
+			if p, ok := sum.progressive[name]; ok {
+				log.Printf("  %s: stalls: %d, fails: %d, transferred: %0.2f MiB",
+					name, p.stalls, p.fails, float64(p.bytes)/1048576)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHistogram_percentile(t *testing.T) {
+	h := newHistogram()
+	for i := 1; i <= 100; i++ {
+		h.add(time.Duration(i) * time.Millisecond)
+	}
+	h.add(2 * histogramMax)
+	// the 51ms sample is reported with the upper bound of its bucket
+	if got := h.percentile(50); got != 52*time.Millisecond {
+		t.Errorf("histogram.percentile(50) got = %v, expected 52ms", got)
+	}
+	if got := h.percentile(100); got != 2*histogramMax {
+		t.Errorf("histogram.percentile(100) got = %v, expected %v", got, 2*histogramMax)
+	}
+}
+
+func TestStats_Summary(t *testing.T) {
+	stats := NewStats()
+	stats.Add(&Result{Code: 200, Size: 100, Duration: time.Second})
+	stats.Add(&Result{Code: 404})
+	stats.Add(&Result{Err: errors.New("dial tcp: connection refused")})
+	stats.Add(&Result{Err: errors.New("dial tcp: connection refused")})
+
+	sum := stats.Summary(&Scenario{})
+	if sum.Requests != 4 || sum.Hits != 1 || sum.ErrorRate != 0.75 {
+		t.Errorf("Stats.Summary() got = %d requests, %d hits, %v error rate", sum.Requests, sum.Hits, sum.ErrorRate)
+	}
+	if sum.ErrorKinds["HTTP 404"] != 1 || sum.ErrorKinds["connection refused"] != 2 {
+		t.Errorf("Stats.Summary() got error kinds %v", sum.ErrorKinds)
+	}
+}
+
+func TestStats_Summary_playlistFails(t *testing.T) {
+	// a dead relay: every playlist load fails and no segment is requested
+	stats := NewStats()
+	stats.SetPlaylists(10, 10)
+	sum := stats.Summary(&Scenario{})
+	if sum.Requests != 0 || sum.PlaylistFails != 10 || sum.ErrorRate != 1 {
+		t.Errorf("Stats.Summary() got = %d requests, %d playlist fails, %v error rate", sum.Requests, sum.PlaylistFails, sum.ErrorRate)
+	}
+}
+
+func TestStats_Log_resetsStreams(t *testing.T) {
+	stream, err := ParseStream("http://relay/hls/a/native_hd.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStats()
+	stats.Add(&Result{Code: 200, Size: 100, Stream: stream})
+	// a single stream isn't logged separately, but its interval has to end anyway
+	stats.Log(&Scenario{Segmented: []*Stream{stream}})
+	if c := stats.intervalPerStream[stream.String()]; c.hits != 0 || c.bytes != 0 {
+		t.Errorf("Stats.Log() left %d hits, %d bytes in the stream interval", c.hits, c.bytes)
+	}
+	if c := stats.totalPerStream[stream.String()]; c.hits != 1 {
+		t.Errorf("Stats.Log() got %d hits in the stream total, expected 1", c.hits)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Format of a stream, derived from the URL extension
+type Format string
+
+const (
+	FormatHLS         Format = "hls"
+	FormatDASH        Format = "dash"
+	FormatProgressive Format = "progressive"
+)
+
+// formatFromPath returns the stream format for a URL path
+func formatFromPath(p string) (Format, error) {
+	switch path.Ext(p) {
+	case ".mpd":
+		return FormatDASH, nil
+	case ".m3u8":
+		return FormatHLS, nil
+	case ".webm", ".mkv", ".ts", ".mp3", ".opus", ".ogg", ".aac":
+		return FormatProgressive, nil
+	default:
+		return "", fmt.Errorf("Unknown playlist format: '%v'", path.Ext(p))
+	}
+}
+
+// Stream is a single source URL together with its share of the simulated audience
+type Stream struct {
+	URL    *url.URL
+	Format Format
+	Weight float64
+
+	// clients per segment, computed by Distribute
+	clients float64
+	// fractional clients carried over to the next segment
+	credit float64
+	// long-lived connections for progressive streams, computed by Distribute
+	connections uint
+	progressive progressiveStats
+}
+
+// ParseStream parses a stream argument of the form URL[#weight].
+// The URL fragment is never sent to the server so it is used to carry the weight.
+func ParseStream(arg string) (*Stream, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{
+		URL:    u,
+		Weight: 1,
+	}
+	if u.Fragment != "" {
+		weight, err := strconv.ParseFloat(u.Fragment, 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight '%v' for %v", u.Fragment, arg)
+		}
+		s.Weight = weight
+		u.Fragment = ""
+	}
+	s.Format, err = formatFromPath(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%v for %v", err, u.String())
+	}
+	return s, nil
+}
+
+// String returns the stream URL without weight
+func (s *Stream) String() string {
+	return s.URL.String()
+}
+
+// ParseFormatWeights parses a list of format weights like "hls=2,dash=1"
+func ParseFormatWeights(list string) (map[Format]float64, error) {
+	weights := make(map[Format]float64)
+	if list == "" {
+		return weights, nil
+	}
+	for _, entry := range strings.Split(list, ",") {
+		split := strings.Split(entry, "=")
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid format weight '%v'", entry)
+		}
+		format := Format(strings.TrimSpace(split[0]))
+		switch format {
+		case FormatHLS, FormatDASH, FormatProgressive:
+		default:
+			return nil, fmt.Errorf("unknown format '%v'", format)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(split[1]), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid format weight '%v'", entry)
+		}
+		weights[format] = weight
+	}
+	return weights, nil
+}
+
+// Segmented returns whether the stream is fetched segment by segment from a playlist
+func (s *Stream) Segmented() bool {
+	return s.Format != FormatProgressive
+}
+
+// Distribute splits the simulated clients between streams proportional to the stream and format weights.
+// On average each segment is requested factor times per segmented stream, so equal weights
+// result in factor clients per stream. The long-lived connections are split between the progressive streams.
+func Distribute(streams []*Stream, formatWeights map[Format]float64, factor uint, connections uint) {
+	var segmented, progressive []*Stream
+	segmentedTotal, progressiveTotal := float64(0), float64(0)
+	for _, s := range streams {
+		if s.Segmented() {
+			segmented = append(segmented, s)
+			segmentedTotal += s.effectiveWeight(formatWeights)
+		} else {
+			progressive = append(progressive, s)
+			progressiveTotal += s.effectiveWeight(formatWeights)
+		}
+	}
+	for _, s := range segmented {
+		if segmentedTotal == 0 {
+			s.clients = 0
+			continue
+		}
+		s.clients = s.effectiveWeight(formatWeights) / segmentedTotal * float64(factor) * float64(len(segmented))
+	}
+
+	// hand out whole connections by largest remainder
+	assigned := uint(0)
+	remainders := make([]float64, len(progressive))
+	for i, s := range progressive {
+		s.connections = 0
+		if progressiveTotal == 0 {
+			continue
+		}
+		exact := s.effectiveWeight(formatWeights) / progressiveTotal * float64(connections)
+		s.connections = uint(exact)
+		remainders[i] = exact - float64(s.connections)
+		assigned += s.connections
+	}
+	for ; assigned < connections && progressiveTotal > 0; assigned++ {
+		best := 0
+		for i := range progressive {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		progressive[best].connections++
+		remainders[best] = -1
+	}
+}
+
+func (s *Stream) effectiveWeight(formatWeights map[Format]float64) float64 {
+	weight := s.Weight
+	if fw, ok := formatWeights[s.Format]; ok {
+		weight *= fw
+	}
+	return weight
+}
+
+// Share returns the fraction of all simulated clients of the same kind
+// (segmented or progressive) assigned to this stream
+func (s *Stream) Share(streams []*Stream) float64 {
+	total := float64(0)
+	for _, other := range streams {
+		if other.Segmented() != s.Segmented() {
+			continue
+		}
+		if other.Segmented() {
+			total += other.clients
+		} else {
+			total += float64(other.connections)
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	if s.Segmented() {
+		return s.clients / total
+	}
+	return float64(s.connections) / total
+}
+
+// nextClients returns how many clients should fetch the next segment.
+// Fractional clients are accumulated, so low-weight streams are still fetched occasionally.
+func (s *Stream) nextClients() uint {
+	s.credit += s.clients
+	n := uint(s.credit)
+	s.credit -= float64(n)
+	return n
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		arg      string
+		url      string
+		format   Format
+		weight   float64
+		expectOk bool
+	}{
+		{"plain", "https://cdn.c3voc.de/hls/s1/native_hd.m3u8", "https://cdn.c3voc.de/hls/s1/native_hd.m3u8", FormatHLS, 1, true},
+		{"weighted", "https://cdn.c3voc.de/dash/s1/manifest.mpd#70", "https://cdn.c3voc.de/dash/s1/manifest.mpd", FormatDASH, 70, true},
+		{"invalidWeight", "https://cdn.c3voc.de/hls/s1/native_hd.m3u8#foo", "", "", 0, false},
+		{"unknownFormat", "https://cdn.c3voc.de/s1/index.html", "", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseStream(tt.arg)
+			if !tt.expectOk {
+				if err == nil {
+					t.Errorf("ParseStream() expected error for %v", tt.arg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.String() != tt.url || s.Format != tt.format || s.Weight != tt.weight {
+				t.Errorf("ParseStream() got = %v %v %v, expected %v %v %v", s, s.Format, s.Weight, tt.url, tt.format, tt.weight)
+			}
+		})
+	}
+}
+
+func TestDistribute(t *testing.T) {
+	hall, _ := ParseStream("https://cdn.c3voc.de/hls/s1/native_hd.m3u8#7")
+	translated, _ := ParseStream("https://cdn.c3voc.de/hls/s1/translated_hd.m3u8#1")
+	dash, _ := ParseStream("https://cdn.c3voc.de/dash/s1/manifest.mpd#1")
+	streams := []*Stream{hall, translated, dash}
+	weights, err := ParseFormatWeights("dash=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Distribute(streams, weights, 10, 0)
+
+	// 30 clients in total, split 7:1:2
+	expected := []float64{21, 3, 6}
+	for i, s := range streams {
+		if s.clients != expected[i] {
+			t.Errorf("Distribute() %v got = %v clients, expected %v", s, s.clients, expected[i])
+		}
+	}
+
+	// fractional clients carry over between segments
+	translated.clients = 0.5
+	sum := uint(0)
+	for i := 0; i < 10; i++ {
+		sum += translated.nextClients()
+	}
+	if sum != 5 {
+		t.Errorf("nextClients() got = %v clients over 10 segments, expected 5", sum)
+	}
+}
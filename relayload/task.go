@@ -2,16 +2,20 @@ package main
 
 import (
 	"net/url"
+	"time"
 )
 
 // Task encapsulates a work item that should go in a work pool.
 type Task struct {
-	URL *url.URL
+	URL    *url.URL
+	Stream *Stream
 }
 
 // Result contains info communicated back to the statistics collector
 type Result struct {
-	Err  error
-	Code int
-	Size int64
+	Err      error
+	Code     int
+	Size     int64
+	Duration time.Duration
+	Stream   *Stream
 }
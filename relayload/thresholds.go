@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Thresholds a run has to meet to pass
+type Thresholds struct {
+	MaxErrorRate float64       // fraction of failed requests, negative to disable
+	MaxP99       time.Duration // 99th percentile segment latency, 0 to disable
+	MinRate      float64       // segment throughput in Mbit/s, 0 to disable
+	MaxStalls    int64         // progressive connection stalls, negative to disable
+}
+
+// enabled returns whether any threshold is checked
+func (t *Thresholds) enabled() bool {
+	return t.MaxErrorRate >= 0 || t.MaxP99 > 0 || t.MinRate > 0 || t.MaxStalls >= 0
+}
+
+// Check returns a description of every threshold violated by the run.
+// A run which didn't complete a single request or transfer progressive data fails every enabled check.
+func (t *Thresholds) Check(sum *Summary) []string {
+	var violations []string
+	if t.enabled() && sum.Requests == 0 && sum.ProgressiveBytes == 0 {
+		violations = append(violations, "no requests completed")
+	}
+	if t.MaxErrorRate >= 0 && sum.ErrorRate > t.MaxErrorRate {
+		violations = append(violations, fmt.Sprintf("error rate %0.3f%% above %0.3f%%",
+			sum.ErrorRate*100, t.MaxErrorRate*100))
+	}
+	if t.MaxP99 > 0 && sum.LatencyP99 > t.MaxP99 {
+		violations = append(violations, fmt.Sprintf("p99 latency %v above %v",
+			sum.LatencyP99.Round(time.Millisecond), t.MaxP99))
+	}
+	if t.MinRate > 0 && sum.Rate < t.MinRate {
+		violations = append(violations, fmt.Sprintf("rate %0.2f Mbit/s below %0.2f Mbit/s",
+			sum.Rate, t.MinRate))
+	}
+	if t.MaxStalls >= 0 && int64(sum.ProgressiveStalls) > t.MaxStalls {
+		violations = append(violations, fmt.Sprintf("%d progressive stalls above %d",
+			sum.ProgressiveStalls, t.MaxStalls))
+	}
+	return violations
+}
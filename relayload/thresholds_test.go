@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThresholds_Check(t *testing.T) {
+	sum := &Summary{
+		Requests:   1000,
+		ErrorRate:  0.002,
+		LatencyP99: 1500 * time.Millisecond,
+		Rate:       80,
+	}
+	passing := &Thresholds{MaxErrorRate: 0.01, MaxP99: 2 * time.Second, MinRate: 50, MaxStalls: -1}
+	if violations := passing.Check(sum); len(violations) != 0 {
+		t.Errorf("Thresholds.Check() got = %v, expected no violations", violations)
+	}
+	failing := &Thresholds{MaxErrorRate: 0.001, MaxP99: time.Second, MinRate: 100, MaxStalls: -1}
+	if violations := failing.Check(sum); len(violations) != 3 {
+		t.Errorf("Thresholds.Check() got = %v, expected 3 violations", violations)
+	}
+	disabled := &Thresholds{MaxErrorRate: -1, MaxStalls: -1}
+	if violations := disabled.Check(sum); len(violations) != 0 {
+		t.Errorf("Thresholds.Check() got = %v, expected no violations", violations)
+	}
+
+	// a dead relay fails even the thresholds that only look at successful requests
+	dead := &Summary{}
+	if violations := (&Thresholds{MaxErrorRate: -1, MaxP99: time.Second, MaxStalls: -1}).Check(dead); len(violations) != 1 {
+		t.Errorf("Thresholds.Check() got = %v, expected no requests violation", violations)
+	}
+	if violations := disabled.Check(dead); len(violations) != 0 {
+		t.Errorf("Thresholds.Check() got = %v, expected no violations", violations)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// throttledReader limits reads from the underlying reader to a fixed rate
+type throttledReader struct {
+	reader io.Reader
+	rate   int64 // bytes per second
+	start  time.Time
+	read   int64
+}
+
+// newThrottledReader returns a reader limited to bitrate bits per second.
+// A bitrate <= 0 returns the reader unchanged.
+func newThrottledReader(reader io.Reader, bitrate int64) io.Reader {
+	if bitrate <= 0 {
+		return reader
+	}
+	rate := bitrate / 8
+	if rate < 1 {
+		rate = 1
+	}
+	return &throttledReader{
+		reader: reader,
+		rate:   rate,
+		start:  time.Now(),
+	}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// read at most a tenth of a second worth of data at once
+	if max := t.rate / 10; max > 0 && int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := t.reader.Read(p)
+	t.read += int64(n)
+
+	// sleep until the data read so far is due
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// CompareRow is the summary of a single run in a comparison
+type CompareRow struct {
+	Name          string
+	Hostname      string
+	CPUModel      string
+	Cores         int
+	Capacity      int
+	Unit          string
+	Jobs          int // jobs at the recommended capacity
+	Converged     bool
+	Confidence    float64
+	CPUAvg        float64 // at the recommended capacity, 0 if unknown
+	Power         float64 // watts at the recommended capacity, 0 if unknown
+	JobsPerCore   float64
+	JobsPerKWatt  float64 // 0 if the power is unknown
+	hasResources  bool
+	hasConfidence bool
+}
+
+// LoadReport reads the json report of a run
+func LoadReport(filename string) (*Report, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rep Report
+	if err := json.Unmarshal(content, &rep); err != nil {
+		return nil, fmt.Errorf("invalid report %v: %v", filename, err)
+	}
+	return &rep, nil
+}
+
+// NewCompareRow summarizes a report, runs without a label are named by the report file
+func NewCompareRow(rep *Report, filename string) *CompareRow {
+	row := &CompareRow{
+		Name:      rep.Label,
+		Hostname:  rep.Hostname,
+		CPUModel:  rep.CPUModel,
+		Cores:     rep.Cores,
+		Capacity:  rep.Capacity,
+		Unit:      rep.Unit,
+		Converged: rep.Converged,
+	}
+	if row.Name == "" {
+		row.Name = strings.TrimSuffix(path.Base(filename), ".json")
+	}
+	for _, tr := range rep.Templates {
+		row.Jobs += tr.Capacity
+	}
+	for _, entry := range rep.JobCounts {
+		if entry.Jobs != rep.Capacity {
+			continue
+		}
+		row.Confidence, row.hasConfidence = entry.Confidence, true
+		if entry.Resources != nil {
+			row.CPUAvg, row.Power, row.hasResources = entry.Resources.CPUAvg, entry.Resources.PowerAvg, true
+		}
+	}
+	if row.Cores > 0 {
+		row.JobsPerCore = float64(row.Jobs) / float64(row.Cores)
+	}
+	if row.Power > 0 {
+		row.JobsPerKWatt = float64(row.Jobs) / row.Power * 1000
+	}
+	return row
+}
+
+var compareColumns = []string{"run", "host", "cpu", "cores", "capacity", "jobs", "converged", "confidence",
+	"cpu at capacity", "power at capacity", "jobs/core", "jobs/kW"}
+
+// fields formats the row for the compareColumns
+func (row *CompareRow) fields() []string {
+	optional := func(ok bool, format string, value float64) string {
+		if !ok {
+			return "-"
+		}
+		return fmt.Sprintf(format, value)
+	}
+	return []string{
+		row.Name,
+		row.Hostname,
+		row.CPUModel,
+		strconv.Itoa(row.Cores),
+		fmt.Sprintf("%d %s", row.Capacity, row.Unit),
+		strconv.Itoa(row.Jobs),
+		strconv.FormatBool(row.Converged),
+		optional(row.hasConfidence, "%0.1f", row.Confidence),
+		optional(row.hasResources, "%0.1f%%", row.CPUAvg),
+		optional(row.Power > 0, "%0.1fW", row.Power),
+		fmt.Sprintf("%0.2f", row.JobsPerCore),
+		optional(row.JobsPerKWatt > 0, "%0.1f", row.JobsPerKWatt),
+	}
+}
+
+// sortRows orders the rows by the given column, best first
+func sortRows(rows []*CompareRow, by string) error {
+	var less func(a, b *CompareRow) bool
+	switch by {
+	case "":
+		return nil
+	case "jobs":
+		less = func(a, b *CompareRow) bool { return a.Jobs > b.Jobs }
+	case "jobs-per-core":
+		less = func(a, b *CompareRow) bool { return a.JobsPerCore > b.JobsPerCore }
+	case "jobs-per-watt":
+		less = func(a, b *CompareRow) bool { return a.JobsPerKWatt > b.JobsPerKWatt }
+	default:
+		return fmt.Errorf("unknown sort column '%v'", by)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return less(rows[i], rows[j])
+	})
+	return nil
+}
+
+// writeComparison writes the rows as an aligned table, csv or markdown
+func writeComparison(w io.Writer, rows []*CompareRow, format string) error {
+	switch format {
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(compareColumns, "\t")+"\t")
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row.fields(), "\t")+"\t")
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(compareColumns)
+		for _, row := range rows {
+			cw.Write(row.fields())
+		}
+		cw.Flush()
+		return cw.Error()
+	case "markdown":
+		fmt.Fprintf(w, "| %s |\n", strings.Join(compareColumns, " | "))
+		fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(compareColumns)))
+		for _, row := range rows {
+			fmt.Fprintf(w, "| %s |\n", strings.Join(row.fields(), " | "))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format '%v'", format)
+}
+
+// runCompare implements the compare subcommand
+func runCompare(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+	format := flags.String("format", "text", "output format: text, csv or markdown")
+	sortBy := flags.String("sort", "", "sort by jobs, jobs-per-core or jobs-per-watt, in the given order if empty")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: transcoderload compare [options] <report.json>...\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no reports given")
+	}
+	var rows []*CompareRow
+	for _, filename := range flags.Args() {
+		rep, err := LoadReport(filename)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, NewCompareRow(rep, filename))
+	}
+	if err := sortRows(rows, *sortBy); err != nil {
+		return err
+	}
+	return writeComparison(w, rows, *format)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewCompareRow(t *testing.T) {
+	rep := &Report{
+		Hostname: "encoder1",
+		Cores:    8,
+		Capacity: 2,
+		Unit:     "units",
+		Templates: []*TemplateReport{
+			{Name: "hd", Weight: 1, Capacity: 2},
+			{Name: "sd", Weight: 2, Capacity: 4},
+		},
+		JobCounts: []*ReportEntry{
+			{Jobs: 1, Confidence: 3},
+			{Jobs: 2, Confidence: 1.5, Resources: &ResourceStats{CPUAvg: 80, PowerAvg: 120}},
+			{Jobs: 3, Confidence: -1},
+		},
+	}
+	row := NewCompareRow(rep, "/tmp/runs/vaapi.json")
+	if row.Name != "vaapi" || row.Unit != "units" || row.Jobs != 6 || row.Confidence != 1.5 || row.CPUAvg != 80 {
+		t.Errorf("NewCompareRow() got = %+v", row)
+	}
+	if row.JobsPerCore != 0.75 || row.JobsPerKWatt != 50 {
+		t.Errorf("NewCompareRow() got %v jobs/core, %v jobs/kW, expected 0.75, 50", row.JobsPerCore, row.JobsPerKWatt)
+	}
+
+	rep.Label = "software"
+	rep.JobCounts = nil
+	row = NewCompareRow(rep, "software.json")
+	fields := row.fields()
+	if row.Name != "software" || fields[7] != "-" || fields[8] != "-" || fields[11] != "-" {
+		t.Errorf("fields() without resources got = %v", fields)
+	}
+}
+
+func Test_writeComparison(t *testing.T) {
+	rows := []*CompareRow{
+		{Name: "a", Capacity: 2, Unit: "jobs", Jobs: 2, Cores: 4, JobsPerCore: 0.5},
+		{Name: "b", Capacity: 6, Unit: "jobs", Jobs: 6, Cores: 4, JobsPerCore: 1.5},
+	}
+	if err := sortRows(rows, "jobs-per-core"); err != nil {
+		t.Fatal(err)
+	}
+	if rows[0].Name != "b" {
+		t.Errorf("sortRows() got %v first, expected b", rows[0].Name)
+	}
+	for _, format := range []string{"text", "csv", "markdown"} {
+		var b strings.Builder
+		if err := writeComparison(&b, rows, format); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); len(lines) < 3 || !strings.Contains(lines[0], "jobs/core") {
+			t.Errorf("writeComparison(%v) got:\n%v", format, b.String())
+		}
+	}
+	if err := writeComparison(&strings.Builder{}, rows, "xml"); err == nil {
+		t.Errorf("writeComparison() expected error for unknown format")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// LoadConfig sets the flags from a json config file mapping flag names to values, e.g. {"base-hold": "1m"}.
+// Flags given on the command line take precedence over the file.
+func LoadConfig(flags *flag.FlagSet, filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	// keep numbers as written, so integer flags accept them
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("invalid config %v: %v", filename, err)
+	}
+
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || flags.Lookup(name) == nil {
+			return fmt.Errorf("config %v: unknown option '%v'", filename, name)
+		}
+		if given[name] {
+			continue
+		}
+		var value string
+		switch v := values[name].(type) {
+		case string:
+			value = v
+		case json.Number, bool:
+			value = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("config %v: option '%v' must be a string, number or boolean", filename, name)
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("config %v: option '%v': %v", filename, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		args     []string
+		config   string
+		baseHold time.Duration
+		limit    int
+		expected string
+	}{
+		{"defaults", nil, `{}`, 20 * time.Second, 5, ""},
+		{"from file", nil, `{"base-hold": "1m", "stall-limit": 10, "incremental": true}`, time.Minute, 10, ""},
+		{"command line wins", []string{"-base-hold", "30s"}, `{"base-hold": "1m"}`, 30 * time.Second, 5, ""},
+		{"unknown option", nil, `{"hold": "1m"}`, 0, 0, "unknown option 'hold'"},
+		{"invalid value", nil, `{"stall-limit": 2.5}`, 0, 0, "option 'stall-limit'"},
+		{"nested value", nil, `{"stall-limit": [1]}`, 0, 0, "option 'stall-limit' must be"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			baseHold := flags.Duration("base-hold", 20*time.Second, "")
+			limit := flags.Int("stall-limit", 5, "")
+			flags.Bool("incremental", false, "")
+			flags.String("config", "", "")
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			filename := path.Join(dir, string(rune('a'+i))+".json")
+			if err := ioutil.WriteFile(filename, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := LoadConfig(flags, filename)
+			if tt.expected != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expected) {
+					t.Errorf("LoadConfig() error = %v, expected %q", err, tt.expected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if *baseHold != tt.baseHold || *limit != tt.limit {
+				t.Errorf("got base-hold %v, stall-limit %d, expected %v, %d", *baseHold, *limit, tt.baseHold, tt.limit)
+			}
+		})
+	}
+}
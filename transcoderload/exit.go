@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ExitReason is why a job ended without being stopped by the runner
+type ExitReason string
+
+const (
+	ExitStall       ExitReason = "stall"
+	ExitKilled      ExitReason = "killed"       // SIGKILL or out of memory
+	ExitCrash       ExitReason = "crash"        // exit code or signal after the job was running
+	ExitEarly       ExitReason = "early exit"   // regular exit, e.g. at the end of the input
+	ExitStartFailed ExitReason = "start failed" // the command couldn't be executed
+	ExitNoProgress  ExitReason = "no progress"  // the command never reported its progress
+)
+
+// CapacityFailure returns whether the exit shows that the machine is overloaded
+func (r ExitReason) CapacityFailure() bool {
+	return r == ExitStall || r == ExitKilled
+}
+
+// JobExit describes how a job ended
+type JobExit struct {
+	Reason ExitReason
+	Detail string
+	Code   int    // exit code, -1 if the process was killed by a signal or didn't start
+	Signal string // terminating signal
+}
+
+func (e *JobExit) String() string {
+	s := string(e.Reason)
+	if e.Detail != "" {
+		s += " (" + e.Detail + ")"
+	}
+	return s
+}
+
+// classifyExit determines why a job ended
+func classifyExit(stallReason string, startErr error, connected bool, state *os.ProcessState, errors map[string]int) *JobExit {
+	exit := &JobExit{Code: -1}
+	if startErr != nil {
+		exit.Reason, exit.Detail = ExitStartFailed, startErr.Error()
+		return exit
+	}
+	var signal syscall.Signal
+	if state != nil {
+		exit.Code = state.ExitCode()
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			signal = status.Signal()
+			exit.Signal = signal.String()
+		}
+	}
+
+	switch {
+	case stallReason != "":
+		exit.Reason, exit.Detail = ExitStall, stallReason
+	case signal == syscall.SIGKILL:
+		exit.Reason, exit.Detail = ExitKilled, "SIGKILL, possibly by the out of memory killer"
+	case errors["out of memory"] > 0:
+		exit.Reason, exit.Detail = ExitKilled, "out of memory"
+	case !connected:
+		exit.Reason = ExitNoProgress
+		exit.Detail = "exited without reporting progress"
+	case signal != 0:
+		exit.Reason, exit.Detail = ExitCrash, exit.Signal
+	case exit.Code != 0:
+		exit.Reason, exit.Detail = ExitCrash, fmt.Sprintf("exit code %d", exit.Code)
+	default:
+		exit.Reason = ExitEarly
+	}
+	return exit
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// processState runs a shell command and returns its state
+func processState(command string) *os.ProcessState {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Run()
+	return cmd.ProcessState
+}
+
+func Test_classifyExit(t *testing.T) {
+	tests := []struct {
+		name        string
+		stallReason string
+		startErr    error
+		connected   bool
+		command     string
+		errors      map[string]int
+		expected    ExitReason
+		code        int
+	}{
+		{"start failed", "", errors.New("exec: not found"), false, "", nil, ExitStartFailed, -1},
+		{"stall", "speed N/A", nil, true, "kill -TERM $$", nil, ExitStall, -1},
+		{"oom killer", "", nil, true, "kill -KILL $$", nil, ExitKilled, -1},
+		{"out of memory", "", nil, true, "exit 1", map[string]int{"out of memory": 1}, ExitKilled, 1},
+		{"no progress", "", nil, false, "exit 1", nil, ExitNoProgress, 1},
+		{"segfault", "", nil, true, "kill -SEGV $$", nil, ExitCrash, -1},
+		{"exit code", "", nil, true, "exit 3", nil, ExitCrash, 3},
+		{"end of input", "", nil, true, "exit 0", nil, ExitEarly, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state *os.ProcessState
+			if tt.command != "" {
+				state = processState(tt.command)
+			}
+			exit := classifyExit(tt.stallReason, tt.startErr, tt.connected, state, tt.errors)
+			if exit.Reason != tt.expected || exit.Code != tt.code {
+				t.Errorf("classifyExit() got = %v (code %d), expected %v (code %d)", exit, exit.Code, tt.expected, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import "encoding/binary"
+
+// maxBoxSize is the largest moov or moof box that is buffered for parsing
+const maxBoxSize = 16 << 20
+
+// sampleNonSync is the sample_is_non_sync_sample bit of the sample flags
+const sampleNonSync = 0x10000
+
+type fmp4Track struct {
+	timescale uint32
+	video     bool
+}
+
+// fmp4Parser follows a fragmented MP4 stream.
+// It reads the track timescales from the moov box and checks the decode time of each fragment of the first
+// video track (or the first track) against the end of the previous fragment.
+type fmp4Parser struct {
+	events      streamEvents
+	buf         []byte
+	skip        int64 // bytes left of a box which isn't parsed
+	skipAll     bool  // the last box extends to the end of the stream
+	tracks      map[uint32]*fmp4Track
+	clockTrack  uint32
+	expected    uint64 // decode time at which the next fragment should start
+	hasExpected bool
+}
+
+func newFMP4Parser(events streamEvents) *fmp4Parser {
+	return &fmp4Parser{
+		events: events,
+		tracks: make(map[uint32]*fmp4Track),
+	}
+}
+
+// Write parses the boxes in p
+func (f *fmp4Parser) Write(p []byte) (int, error) {
+	if f.skipAll {
+		return len(p), nil
+	}
+	f.buf = append(f.buf, p...)
+	for {
+		if f.skip > 0 {
+			n := int64(len(f.buf))
+			if n > f.skip {
+				n = f.skip
+			}
+			f.buf = f.buf[n:]
+			f.skip -= n
+			if f.skip > 0 {
+				break
+			}
+		}
+		if len(f.buf) < 8 {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(f.buf))
+		boxType := string(f.buf[4:8])
+		header := int64(8)
+		if size == 1 {
+			if len(f.buf) < 16 {
+				break
+			}
+			size = int64(binary.BigEndian.Uint64(f.buf[8:]))
+			header = 16
+		}
+		if size == 0 {
+			f.skipAll = true
+			f.buf = nil
+			break
+		}
+		if size < header {
+			// corrupt stream, stop parsing
+			f.events.continuityError()
+			f.skipAll = true
+			f.buf = nil
+			break
+		}
+		if (boxType != "moov" && boxType != "moof") || size > maxBoxSize {
+			f.skip = size
+			continue
+		}
+		if int64(len(f.buf)) < size {
+			break
+		}
+		body := f.buf[header:size]
+		if boxType == "moov" {
+			f.parseMoov(body)
+		} else {
+			f.parseMoof(body)
+		}
+		f.buf = f.buf[size:]
+	}
+	f.buf = append([]byte(nil), f.buf...)
+	return len(p), nil
+}
+
+// boxes calls fn for each child box in data
+func boxes(data []byte, fn func(boxType string, body []byte)) {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		header := 8
+		if size == 1 && len(data) >= 16 {
+			size = int(binary.BigEndian.Uint64(data[8:]))
+			header = 16
+		}
+		if size == 0 {
+			size = len(data)
+		}
+		if size < header || size > len(data) {
+			return
+		}
+		fn(string(data[4:8]), data[header:size])
+		data = data[size:]
+	}
+}
+
+func (f *fmp4Parser) parseMoov(body []byte) {
+	boxes(body, func(boxType string, trak []byte) {
+		if boxType != "trak" {
+			return
+		}
+		var id uint32
+		track := &fmp4Track{}
+		boxes(trak, func(boxType string, b []byte) {
+			switch boxType {
+			case "tkhd":
+				id = fullBoxUint32(b, 12, 20)
+			case "mdia":
+				boxes(b, func(boxType string, b []byte) {
+					switch boxType {
+					case "mdhd":
+						track.timescale = fullBoxUint32(b, 12, 20)
+					case "hdlr":
+						track.video = len(b) >= 12 && string(b[8:12]) == "vide"
+					}
+				})
+			}
+		})
+		if id == 0 || track.timescale == 0 {
+			return
+		}
+		f.tracks[id] = track
+		if clock, ok := f.tracks[f.clockTrack]; !ok || (track.video && !clock.video) {
+			f.clockTrack = id
+			f.hasExpected = false
+		}
+	})
+}
+
+// fullBoxUint32 reads a value at the offset for version 0 or version 1 of a full box
+func fullBoxUint32(b []byte, offset0 int, offset1 int) uint32 {
+	offset := offset0
+	if len(b) > 0 && b[0] == 1 {
+		offset = offset1
+	}
+	if len(b) < offset+4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b[offset:])
+}
+
+func (f *fmp4Parser) parseMoof(body []byte) {
+	boxes(body, func(boxType string, traf []byte) {
+		if boxType != "traf" {
+			return
+		}
+		var id, defaultDuration, defaultFlags uint32
+		var decodeTime, duration uint64
+		hasTime, hasFlags, keyframe := false, false, false
+		boxes(traf, func(boxType string, b []byte) {
+			switch boxType {
+			case "tfhd":
+				if len(b) < 8 {
+					return
+				}
+				flags := binary.BigEndian.Uint32(b) & 0xffffff
+				id = binary.BigEndian.Uint32(b[4:])
+				fields := b[8:]
+				for _, field := range []struct {
+					flag uint32
+					size int
+					dst  *uint32
+				}{{0x01, 8, nil}, {0x02, 4, nil}, {0x08, 4, &defaultDuration}, {0x10, 4, nil}, {0x20, 4, &defaultFlags}} {
+					if flags&field.flag == 0 {
+						continue
+					}
+					if len(fields) < field.size {
+						return
+					}
+					if field.dst != nil {
+						*field.dst = binary.BigEndian.Uint32(fields)
+						if field.flag == 0x20 {
+							hasFlags = true
+							keyframe = defaultFlags&sampleNonSync == 0
+						}
+					}
+					fields = fields[field.size:]
+				}
+			case "tfdt":
+				if len(b) >= 12 && b[0] == 1 {
+					decodeTime, hasTime = binary.BigEndian.Uint64(b[4:]), true
+				} else if len(b) >= 8 {
+					decodeTime, hasTime = uint64(binary.BigEndian.Uint32(b[4:])), true
+				}
+			case "trun":
+				d, first, ok := trunDuration(b, defaultDuration)
+				duration += d
+				if ok {
+					hasFlags, keyframe = true, first&sampleNonSync == 0
+				}
+			}
+		})
+		// fragments before the init segment can't be timed
+		track, ok := f.tracks[id]
+		if !ok || id != f.clockTrack || !hasTime {
+			return
+		}
+		if f.hasExpected {
+			delta := int64(decodeTime) - int64(f.expected)
+			if delta < 0 {
+				delta = -delta
+			}
+			if delta > int64(timestampJump)*int64(track.timescale) {
+				f.events.timestampJump()
+			}
+		}
+		f.expected, f.hasExpected = decodeTime+duration, true
+		seconds := float64(decodeTime) / float64(track.timescale)
+		f.events.clock(seconds)
+		if hasFlags && keyframe && track.video {
+			f.events.keyframe(seconds)
+		}
+	})
+}
+
+// trunDuration returns the duration of the samples in a track run and the flags of its first sample
+func trunDuration(b []byte, defaultDuration uint32) (duration uint64, firstFlags uint32, hasFlags bool) {
+	if len(b) < 8 {
+		return 0, 0, false
+	}
+	flags := binary.BigEndian.Uint32(b) & 0xffffff
+	count := int(binary.BigEndian.Uint32(b[4:]))
+	b = b[8:]
+	if flags&0x01 != 0 {
+		if len(b) < 4 {
+			return 0, 0, false
+		}
+		b = b[4:]
+	}
+	if flags&0x04 != 0 {
+		if len(b) < 4 {
+			return 0, 0, false
+		}
+		firstFlags, hasFlags = binary.BigEndian.Uint32(b), true
+		b = b[4:]
+	}
+	sampleSize := 0
+	for _, flag := range []uint32{0x100, 0x200, 0x400, 0x800} {
+		if flags&flag != 0 {
+			sampleSize += 4
+		}
+	}
+	for i := 0; i < count; i++ {
+		if len(b) < sampleSize {
+			break
+		}
+		sample := b
+		sampleDuration := defaultDuration
+		if flags&0x100 != 0 {
+			sampleDuration = binary.BigEndian.Uint32(sample)
+			sample = sample[4:]
+		}
+		if flags&0x200 != 0 {
+			sample = sample[4:]
+		}
+		if flags&0x400 != 0 && i == 0 && !hasFlags {
+			firstFlags, hasFlags = binary.BigEndian.Uint32(sample), true
+		}
+		duration += uint64(sampleDuration)
+		b = b[sampleSize:]
+	}
+	return duration, firstFlags, hasFlags
+}
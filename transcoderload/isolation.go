@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cpuPeriod is the cgroup cpu.max period in microseconds
+const cpuPeriod = 100000
+
+// IsolationConfig configures how jobs are pinned to cores and placed in cgroups
+type IsolationConfig struct {
+	pin          string  // none, cores or numa
+	pinCores     int     // cores per job, 0 for a whole NUMA node
+	cgroup       string  // cgroup v2 directory to create the job cgroups in
+	cgroupCPUs   float64 // cpu.max in cores, 0 for no limit
+	cgroupMemory uint64  // memory.max in bytes, 0 for no limit
+}
+
+// Isolation assigns core sets and cgroups to jobs
+type Isolation struct {
+	config *IsolationConfig
+	slots  [][]int // core sets jobs are pinned to
+	usage  []int   // running jobs per slot
+	runDir string  // cgroup of this run
+	mutex  sync.Mutex
+}
+
+// NewIsolation reads the cpu topology and creates the cgroup of the run
+func NewIsolation(config *IsolationConfig) (*Isolation, error) {
+	iso := &Isolation{
+		config: config,
+	}
+	var err error
+	switch config.pin {
+	case "", "none":
+	case "cores":
+		var cpus []int
+		cpus, err = onlineCPUs()
+		iso.slots = splitCPUs(cpus, config.pinCores)
+	case "numa":
+		iso.slots, err = numaSlots(config.pinCores)
+	default:
+		return nil, fmt.Errorf("unknown pin mode '%v'", config.pin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	iso.usage = make([]int, len(iso.slots))
+
+	if config.cgroup == "" && (config.cgroupCPUs > 0 || config.cgroupMemory > 0) {
+		return nil, fmt.Errorf("cgroup limits require -cgroup")
+	}
+	if config.cgroup != "" {
+		if err := iso.createRunCgroup(); err != nil {
+			return nil, err
+		}
+	}
+	return iso, nil
+}
+
+// createRunCgroup creates a cgroup for this run with the cpu and memory controllers enabled for the job cgroups
+func (iso *Isolation) createRunCgroup() error {
+	if _, err := os.Stat(path.Join(iso.config.cgroup, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("%v is not a cgroup v2 directory: %v", iso.config.cgroup, err)
+	}
+	iso.runDir = path.Join(iso.config.cgroup, fmt.Sprintf("transcoderload-%d", os.Getpid()))
+	if err := os.Mkdir(iso.runDir, 0755); err != nil {
+		return err
+	}
+	err := ioutil.WriteFile(path.Join(iso.runDir, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+	if err != nil {
+		os.Remove(iso.runDir)
+		return fmt.Errorf("enabling cpu and memory controllers in %v failed, are they enabled in %v/cgroup.subtree_control? %v",
+			iso.runDir, iso.config.cgroup, err)
+	}
+	return nil
+}
+
+// Close removes the cgroup of the run
+func (iso *Isolation) Close() {
+	if iso.runDir != "" {
+		removeCgroup(iso.runDir)
+	}
+}
+
+// Prepare returns the command prefix placing a job into its core set and cgroup
+// and a function which releases them once the job has ended
+func (iso *Isolation) Prepare(name string) (prefix []string, release func(), err error) {
+	var releases []func()
+	release = func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+
+	if len(iso.slots) > 0 {
+		slot := iso.acquireSlot()
+		prefix = append(prefix, "taskset", "-c", formatCPUList(iso.slots[slot]))
+		releases = append(releases, func() {
+			iso.releaseSlot(slot)
+		})
+	}
+
+	if iso.runDir != "" {
+		dir := path.Join(iso.runDir, name)
+		if err := iso.createJobCgroup(dir); err != nil {
+			release()
+			return nil, nil, err
+		}
+		// move the shell into the cgroup before it executes the command, so all children are placed in it
+		prefix = append([]string{"sh", "-c", `echo $$ > "$0/cgroup.procs" && exec "$@"`, dir}, prefix...)
+		releases = append(releases, func() {
+			removeCgroup(dir)
+		})
+	}
+	return prefix, release, nil
+}
+
+func (iso *Isolation) createJobCgroup(dir string) error {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	limits := map[string]string{
+		"cpu.max":    "max",
+		"memory.max": "max",
+	}
+	if iso.config.cgroupCPUs > 0 {
+		limits["cpu.max"] = fmt.Sprintf("%d %d", int(iso.config.cgroupCPUs*cpuPeriod), cpuPeriod)
+	}
+	if iso.config.cgroupMemory > 0 {
+		limits["memory.max"] = strconv.FormatUint(iso.config.cgroupMemory, 10)
+	}
+	for file, value := range limits {
+		if err := ioutil.WriteFile(path.Join(dir, file), []byte(value), 0644); err != nil {
+			os.Remove(dir)
+			return err
+		}
+	}
+	return nil
+}
+
+// acquireSlot returns the core set with the fewest running jobs
+func (iso *Isolation) acquireSlot() int {
+	iso.mutex.Lock()
+	defer iso.mutex.Unlock()
+	slot := 0
+	for i, n := range iso.usage {
+		if n < iso.usage[slot] {
+			slot = i
+		}
+	}
+	iso.usage[slot]++
+	return slot
+}
+
+func (iso *Isolation) releaseSlot(slot int) {
+	iso.mutex.Lock()
+	defer iso.mutex.Unlock()
+	iso.usage[slot]--
+}
+
+// removeCgroup removes a cgroup once its processes have exited
+func removeCgroup(dir string) {
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.Remove(dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Println("removing cgroup failed:", err)
+}
+
+// onlineCPUs returns the online cpus
+func onlineCPUs() ([]int, error) {
+	content, err := ioutil.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		// fall back to the number of cpus
+		cpus := make([]int, runtime.NumCPU())
+		for i := range cpus {
+			cpus[i] = i
+		}
+		return cpus, nil
+	}
+	return parseCPUList(string(content))
+}
+
+// numaSlots splits the cpus of each NUMA node into core sets, alternating between the nodes
+func numaSlots(coresPerJob int) ([][]int, error) {
+	nodes, err := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no NUMA nodes found")
+	}
+	sort.Strings(nodes)
+	var perNode [][][]int
+	for _, node := range nodes {
+		content, err := ioutil.ReadFile(path.Join(node, "cpulist"))
+		if err != nil {
+			return nil, err
+		}
+		cpus, err := parseCPUList(string(content))
+		if err != nil {
+			return nil, err
+		}
+		if len(cpus) == 0 {
+			// memory only node
+			continue
+		}
+		perNode = append(perNode, splitCPUs(cpus, coresPerJob))
+	}
+	var slots [][]int
+	for i := 0; ; i++ {
+		added := false
+		for _, nodeSlots := range perNode {
+			if i < len(nodeSlots) {
+				slots = append(slots, nodeSlots[i])
+				added = true
+			}
+		}
+		if !added {
+			return slots, nil
+		}
+	}
+}
+
+// splitCPUs splits cpus into sets of n, n <= 0 returns a single set
+func splitCPUs(cpus []int, n int) [][]int {
+	if n <= 0 || n >= len(cpus) {
+		return [][]int{cpus}
+	}
+	var sets [][]int
+	for i := 0; i+n <= len(cpus); i += n {
+		sets = append(sets, cpus[i:i+n])
+	}
+	return sets
+}
+
+// parseCPUList parses the kernel cpu list format, e.g. 0-3,8-11
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list '%v'", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpu list '%v'", list)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// formatCPUList formats cpus in the kernel cpu list format
+func formatCPUList(cpus []int) string {
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseBytes parses a byte count with an optional K, M or G suffix
+func parseBytes(s string) (uint64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	multiplier := uint64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%v'", s)
+	}
+	return value * multiplier, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseCPUList(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []int
+		err      bool
+	}{
+		{"0", []int{0}, false},
+		{"0-3\n", []int{0, 1, 2, 3}, false},
+		{"0-1,4,8-9", []int{0, 1, 4, 8, 9}, false},
+		{"", nil, false},
+		{"3-1", nil, true},
+		{"a", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			cpus, err := parseCPUList(tt.list)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseCPUList() error = %v, expected error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(cpus, tt.expected) {
+				t.Errorf("parseCPUList() got = %v, expected %v", cpus, tt.expected)
+			}
+			if err == nil && len(cpus) > 0 {
+				if formatted, _ := parseCPUList(formatCPUList(cpus)); !reflect.DeepEqual(formatted, cpus) {
+					t.Errorf("formatCPUList() got = %v", formatCPUList(cpus))
+				}
+			}
+		})
+	}
+}
+
+func Test_splitCPUs(t *testing.T) {
+	cpus := []int{0, 1, 2, 3, 4}
+	tests := []struct {
+		n        int
+		expected [][]int
+	}{
+		{1, [][]int{{0}, {1}, {2}, {3}, {4}}},
+		{2, [][]int{{0, 1}, {2, 3}}},
+		{0, [][]int{{0, 1, 2, 3, 4}}},
+		{8, [][]int{{0, 1, 2, 3, 4}}},
+	}
+	for _, tt := range tests {
+		if sets := splitCPUs(cpus, tt.n); !reflect.DeepEqual(sets, tt.expected) {
+			t.Errorf("splitCPUs(%d) got = %v, expected %v", tt.n, sets, tt.expected)
+		}
+	}
+}
+
+func Test_acquireSlot(t *testing.T) {
+	iso := &Isolation{
+		slots: [][]int{{0}, {1}, {2}},
+		usage: make([]int, 3),
+	}
+	var got []int
+	for i := 0; i < 4; i++ {
+		got = append(got, iso.acquireSlot())
+	}
+	if !reflect.DeepEqual(got, []int{0, 1, 2, 0}) {
+		t.Errorf("acquireSlot() got = %v", got)
+	}
+	iso.releaseSlot(1)
+	if slot := iso.acquireSlot(); slot != 1 {
+		t.Errorf("acquireSlot() after release got = %v, expected 1", slot)
+	}
+}
+
+func Test_parseBytes(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected uint64
+		err      bool
+	}{
+		{"1024", 1024, false},
+		{"2G", 2 << 30, false},
+		{"512m", 512 << 20, false},
+		{"x", 0, true},
+	}
+	for _, tt := range tests {
+		value, err := parseBytes(tt.s)
+		if (err != nil) != tt.err || value != tt.expected {
+			t.Errorf("parseBytes(%v) got = %v, %v, expected %v", tt.s, value, err, tt.expected)
+		}
+	}
+}
@@ -2,126 +2,210 @@ package main
 
 import (
 	"context"
-	"flag"
-	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"os/exec"
 	"path"
-	"strconv"
-	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type Job struct {
-	Name       string
-	ctx        context.Context
-	cancel     func()
-	stopped    bool
-	stallCount int
-	lastSpeed  float64
-	cmd        *exec.Cmd
-	wg         sync.WaitGroup
+	Name      string
+	Template  *Template
+	Launched  time.Time
+	ctx       context.Context
+	cancel    func()
+	stopped   bool
+	detector  *StallDetector
+	cmd       *exec.Cmd
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+	progress  *Progress
+	pid       int
+	tmpDir    string
+	source    *SourceStream
+	release   func() // releases the job's cores and cgroup
+	log       *JobLog
+	halted    bool           // stopped by the runner
+	reason    string         // stall reason
+	connected bool           // connected to the progress socket or reported progress
+	output    *io.PipeWriter // job output read by the progress parser
+	monitor   *OutputMonitor
+	exit      *JobExit
 }
 
 func (j *Job) Stop() {
+	j.mutex.Lock()
+	j.halted = true
+	j.mutex.Unlock()
 	j.cancel()
 	j.wg.Wait()
 }
 
+// Pid returns the process id of the job, 0 if it wasn't started
+func (j *Job) Pid() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.pid
+}
+
 func (j *Job) Running() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
 	return !j.stopped
 }
 
-func (j *Job) parseSpeed(status map[string]string) {
-	speed, ok := status["speed"]
-	if !ok {
-		return
-	}
-	if speed == "N/A" {
-		j.stallCount++
-		return
-	}
-	speed = strings.TrimSpace(speed)
-	fspeed, err := strconv.ParseFloat(speed[:len(speed)-1], 64)
-	if err != nil {
-		log.Println(err)
-		j.stallCount++
-		return
-	}
-	if fspeed < 1 && j.lastSpeed > fspeed {
-		j.stallCount++
-	} else {
-		j.stallCount = 0
+// ResetDetector discards the progress seen so far by the stall detection
+func (j *Job) ResetDetector() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.detector = NewStallDetector(j.detector.config)
+	if j.monitor != nil {
+		j.monitor.Reset()
 	}
-	j.lastSpeed = fspeed
 }
 
-func (j *Job) parseStatus(content string, status map[string]string) error {
-	res := strings.Split(content, "\n")
-	for _, line := range res {
-		if line == "" {
-			continue
-		}
-		split := strings.Split(line, "=")
-		if len(split) != 2 {
-			return fmt.Errorf("invalid progress '%v'", line)
-		}
-		status[split[0]] = split[1]
-	}
-	return nil
+// Exit returns how the job ended, nil while it is running
+func (j *Job) Exit() *JobExit {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.exit
 }
 
-func (j *Job) handleConnection(conn net.Conn) {
-	buf := make([]byte, 2048)
-	status := make(map[string]string)
+// Connected returns whether the job connected to the progress socket or reported progress
+func (j *Job) Connected() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.connected
+}
+
+// Progress returns the last reported progress of the job, nil if none was reported yet
+func (j *Job) Progress() *Progress {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.progress
+}
+
+func (j *Job) handleProgress(parser ProgressParser) {
 	defer j.cancel()
 	for {
-		length, err := conn.Read(buf)
+		p, err := parser.Next()
 		if err != nil {
-			if err != io.EOF {
-				log.Println("read failed:", err)
+			if err != io.EOF && j.ctx.Err() == nil {
+				log.Println("progress read failed:", err)
 			}
 			return
 		}
-		str := string(buf[:length])
-		if err := j.parseStatus(str, status); err != nil {
-			log.Println("status parse failed", err)
+		j.mutex.Lock()
+		j.progress = p
+		j.connected = true
+		detector := j.detector
+		j.mutex.Unlock()
+		if p.End {
 			return
 		}
-		j.parseSpeed(status)
-		if j.stallCount > 5 {
-			log.Println("stall")
+		now := time.Now()
+		if now.Sub(j.Launched) < detector.config.WarmUp {
+			// slow starts, e.g. filling the lookahead or initializing hardware, don't count as a stall
+			continue
+		}
+		reason := detector.Check(p, now)
+		if reason == "" && j.monitor != nil {
+			reason = j.monitor.Check(now)
+		}
+		if reason != "" {
+			log.Printf("%s: stall (%s) at speed %0.3fx, fps %0.2f, frame %d, dropped %d, duplicated %d",
+				j.Name, reason, p.Speed, p.FPS, p.Frame, p.DropFrames, p.DupFrames)
+			j.mutex.Lock()
+			j.reason = reason
+			j.mutex.Unlock()
 			return
 		}
 	}
 }
 
-func (j *Job) start(exitNotify chan<- struct{}) {
+// start runs the job until it ends and sends it to exitNotify if it ended on its own,
+// unless done is closed because the exits aren't read anymore
+func (j *Job) start(exitNotify chan<- *Job, done <-chan struct{}) {
 	// start process in new group group
 	j.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: 0}
-	j.cmd.Stdout = os.Stdout
-	j.cmd.Stderr = os.Stderr
 
-	err := j.cmd.Start()
+	// capture output through a pipe, so waiting for the process doesn't wait for children holding it open
+	pr, pw, err := os.Pipe()
 	if err != nil {
 		log.Println(err)
+		// nothing will be read, let the output readers finish
+		if j.output != nil {
+			j.output.Close()
+		}
+		j.log.Close()
+	} else {
+		j.cmd.Stdout = pw
+		j.cmd.Stderr = pw
+		go func() {
+			var output io.Writer = j.log
+			if j.output != nil {
+				output = io.MultiWriter(j.log, j.output)
+			}
+			io.Copy(output, pr)
+			pr.Close()
+			if j.output != nil {
+				j.output.Close()
+			}
+			j.log.Close()
+		}()
+	}
+
+	// stop the job's source stream after the job
+	if j.source != nil {
+		defer j.source.Stop()
+	}
+
+	startErr := j.cmd.Start()
+	if pw != nil {
+		pw.Close()
+	}
+	if startErr == nil {
+		j.mutex.Lock()
+		j.pid = j.cmd.Process.Pid
+		j.mutex.Unlock()
+		// kill process group
+		defer syscall.Kill(-j.cmd.Process.Pid, syscall.SIGTERM)
 	}
-	// kill process group
-	defer syscall.Kill(-j.cmd.Process.Pid, syscall.SIGTERM)
 
 	// stop job if command ends
 	go func() {
-		j.cmd.Wait()
-		j.stopped = true
+		if startErr == nil {
+			j.cmd.Wait()
+		}
+		os.RemoveAll(j.tmpDir)
 		j.cancel()
+		// give the job output a moment to be read to the end
+		select {
+		case <-j.log.Closed():
+		case <-time.After(time.Second):
+		}
+		if j.release != nil {
+			j.release()
+		}
+		j.mutex.Lock()
+		j.stopped = true
+		j.exit = classifyExit(j.reason, startErr, j.connected, j.cmd.ProcessState, j.log.Errors())
+		halted := j.halted
+		j.mutex.Unlock()
 		j.wg.Done()
+		if halted {
+			return
+		}
+		// runner checks whether the job is still one of its jobs
 		select {
-		case exitNotify <- struct{}{}:
-		default:
+		case exitNotify <- j:
+		case <-done:
 		}
 	}()
 
@@ -129,43 +213,151 @@ func (j *Job) start(exitNotify chan<- struct{}) {
 	<-j.ctx.Done()
 }
 
-func launch(parentCtx context.Context, name string, dirname string, cmd string, exitNotify chan<- struct{}) (*Job, error) {
-	ctx, cancel := context.WithCancel(parentCtx)
-	filename := path.Join(dirname, name+".sock")
+func launch(parentCtx context.Context, vars *JobVars, template *Template, source *SourceStream, config *RunnerConfig, exitNotify chan<- *Job, done <-chan struct{}) (*Job, error) {
+	name := vars.Name
+	filename := path.Join(config.dirname, name+".sock")
+	vars.TmpDir = path.Join(config.dirname, name)
+	if err := os.Mkdir(vars.TmpDir, 0755); err != nil {
+		return nil, err
+	}
 
-	// create arguments
-	args := []string{"-progress", "unix://" + filename}
-	if cmd == "ffmpeg" {
-		args = append(args, "-v", "warning")
+	// undo the preparations if the job can't be launched
+	var cleanup []func()
+	fail := func(err error) (*Job, error) {
+		for i := len(cleanup) - 1; i >= 0; i-- {
+			cleanup[i]()
+		}
+		return nil, err
 	}
-	args = append(args, flag.Args()...)
+	cleanup = append(cleanup, func() {
+		os.RemoveAll(vars.TmpDir)
+	})
 
-	job := Job{
-		ctx:    ctx,
-		cancel: cancel,
-		Name:   name,
-		cmd:    exec.Command(cmd, args...),
+	var monitor *OutputMonitor
+	if config.monitor != nil {
+		var err error
+		monitor, err = NewOutputMonitor(config.monitor, vars.TmpDir)
+		if err != nil {
+			return fail(err)
+		}
+		cleanup = append(cleanup, func() {
+			monitor.Close()
+		})
+		vars.Output = monitor.URL()
+	}
+	templateArgs, err := template.Expand(vars)
+	if err != nil {
+		return fail(err)
 	}
 
-	ln, err := net.Listen("unix", filename)
+	// create arguments
+	progressArgs, env := config.progress.Prepare(template.Cmd, filename)
+	args := append(progressArgs, templateArgs...)
+
+	// run the command through the wrappers pinning it to cores and placing it in a cgroup
+	command := []string{template.Cmd}
+	var release func()
+	if config.isolation != nil {
+		prefix, r, err := config.isolation.Prepare(name)
+		if err != nil {
+			return fail(err)
+		}
+		command = append(prefix, command...)
+		release = r
+		cleanup = append(cleanup, release)
+	}
+	args = append(command[1:len(command):len(command)], args...)
+
+	logFile := ""
+	if config.logDir != "" {
+		logFile = path.Join(config.logDir, name+".log")
+	}
+	jobLog, err := NewJobLog(logFile)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
+	cleanup = append(cleanup, func() {
+		jobLog.Close()
+	})
 
-	// accept single connection
-	go func() {
-		defer os.Remove(filename)
-		defer ln.Close()
-		conn, err := ln.Accept()
-		job.wg.Add(1)
-		defer job.wg.Done()
+	var ln net.Listener
+	if config.progress.Socket() {
+		ln, err = net.Listen("unix", filename)
 		if err != nil {
-			log.Println("accept failed:", err)
+			return fail(err)
 		}
-		job.handleConnection(conn)
-	}()
+	}
+
+	ctx, cancel := context.WithCancel(parentCtx)
+
+	job := Job{
+		ctx:      ctx,
+		cancel:   cancel,
+		Name:     name,
+		Template: template,
+		Launched: time.Now(),
+		tmpDir:   vars.TmpDir,
+		source:   source,
+		release:  release,
+		monitor:  monitor,
+		log:      jobLog,
+		detector: NewStallDetector(config.stall),
+		cmd:      exec.Command(command[0], args...),
+	}
+	if len(env) > 0 {
+		job.cmd.Env = append(os.Environ(), env...)
+	}
+
+	if monitor != nil {
+		job.wg.Add(1)
+		go func() {
+			defer job.wg.Done()
+			monitor.Run(ctx)
+		}()
+	}
+
+	if ln == nil {
+		// parse the progress from the job output
+		pr, pw := io.Pipe()
+		job.output = pw
+		job.wg.Add(1)
+		go func() {
+			defer job.wg.Done()
+			job.handleProgress(config.progress.NewParser(pr))
+			// keep the job output flowing after a stall
+			io.Copy(ioutil.Discard, pr)
+		}()
+	} else {
+		// accept single connection
+		job.wg.Add(1)
+		go func() {
+			defer job.wg.Done()
+			defer os.Remove(filename)
+			defer ln.Close()
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("accept failed:", err)
+				}
+				return
+			}
+			job.mutex.Lock()
+			job.connected = true
+			job.mutex.Unlock()
+			go func() {
+				<-ctx.Done()
+				conn.Close()
+			}()
+			job.handleProgress(config.progress.NewParser(conn))
+		}()
+		// stop waiting for the connection once the job ends
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+		}()
+	}
 	job.wg.Add(1)
-	go job.start(exitNotify)
+	go job.start(exitNotify, done)
 
 	return &job, nil
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// logTailSize is the number of output lines kept per job
+const logTailSize = 50
+
+// errorPatterns are known error messages in the job output
+var errorPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"conversion failed", regexp.MustCompile(`Conversion failed`)},
+	{"past duration too large", regexp.MustCompile(`Past duration .* too large`)},
+	{"non-monotonous dts", regexp.MustCompile(`Non-monoton(ous|ically increasing) DTS`)},
+	{"out of memory", regexp.MustCompile(`Cannot allocate memory|Out of memory`)},
+	{"hwaccel failed", regexp.MustCompile(`(?i)hwaccel.*(fail|error)|Device creation failed|Failed setup for format|No device available for decoder|Failed to initiali[sz]e (VAAPI|QSV|CUDA)`)},
+	{"input error", regexp.MustCompile(`Connection refused|Connection timed out|Input/output error|Invalid data found when processing input`)},
+}
+
+// classify returns the name of the error pattern matching the line, empty if none matches
+func classify(line string) string {
+	for _, p := range errorPatterns {
+		if p.pattern.MatchString(line) {
+			return p.name
+		}
+	}
+	return ""
+}
+
+// JobLog collects the output of a job.
+// It keeps the last lines, counts known errors and optionally writes the output to a file.
+type JobLog struct {
+	mutex   sync.Mutex
+	lines   []string
+	next    int
+	partial []byte
+	errors  map[string]int
+	file    *os.File
+	closed  chan struct{}
+}
+
+// NewJobLog creates a job log, the output is also written to filename if it isn't empty
+func NewJobLog(filename string) (*JobLog, error) {
+	l := &JobLog{
+		errors: make(map[string]int),
+		closed: make(chan struct{}),
+	}
+	if filename != "" {
+		file, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+		l.file = file
+	}
+	return l, nil
+}
+
+func (l *JobLog) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file != nil {
+		l.file.Write(p)
+	}
+	l.partial = append(l.partial, p...)
+	for {
+		// progress output is terminated by carriage returns
+		i := bytes.IndexAny(l.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		l.addLine(string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (l *JobLog) addLine(line string) {
+	if line == "" {
+		return
+	}
+	if name := classify(line); name != "" {
+		l.errors[name]++
+	}
+	if len(l.lines) < logTailSize {
+		l.lines = append(l.lines, line)
+		return
+	}
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % logTailSize
+}
+
+// Close flushes an incomplete last line and closes the log file
+func (l *JobLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.addLine(string(l.partial))
+	l.partial = nil
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Closed is closed once the job's output has ended
+func (l *JobLog) Closed() <-chan struct{} {
+	return l.closed
+}
+
+// Tail returns up to n of the last output lines
+func (l *JobLog) Tail(n int) []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	lines := append(append([]string(nil), l.lines[l.next:]...), l.lines[:l.next]...)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Errors returns the number of occurrences per known error
+func (l *JobLog) Errors() map[string]int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	errors := make(map[string]int, len(l.errors))
+	for name, count := range l.errors {
+		errors[name] = count
+	}
+	return errors
+}
+
+// errorNames returns the error names sorted by name
+func errorNames(errors map[string]int) []string {
+	names := make([]string, 0, len(errors))
+	for name := range errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestJobLog(t *testing.T) {
+	l, err := NewJobLog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lines split over several writes and progress lines terminated by carriage returns
+	writes := []string{
+		"[mpegts @ 0x1] Non-monotonous DTS in output stream 0:1; previous: 10, current: 9; chang",
+		"ing to 11. This may result in incorrect timestamps in the output file.\n",
+		"frame=  100 fps= 25\rframe=  125 fps= 25\r",
+		"[vost#0:0/h264_vaapi] Device creation failed: -5.\n",
+	}
+	for i := 0; i < logTailSize; i++ {
+		writes = append(writes, fmt.Sprintf("line %d\n", i))
+	}
+	writes = append(writes, "Conversion failed!")
+	for _, w := range writes {
+		l.Write([]byte(w))
+	}
+	l.Close()
+
+	errors := l.Errors()
+	expected := map[string]int{"non-monotonous dts": 1, "hwaccel failed": 1, "conversion failed": 1}
+	if len(errors) != len(expected) {
+		t.Errorf("JobLog.Errors() got = %v, expected %v", errors, expected)
+	}
+	for name, count := range expected {
+		if errors[name] != count {
+			t.Errorf("JobLog.Errors() %v got = %d, expected %d", name, errors[name], count)
+		}
+	}
+
+	tail := l.Tail(3)
+	expectedTail := []string{fmt.Sprintf("line %d", logTailSize-2), fmt.Sprintf("line %d", logTailSize-1), "Conversion failed!"}
+	if len(tail) != len(expectedTail) {
+		t.Fatalf("JobLog.Tail() got = %v, expected %v", tail, expectedTail)
+	}
+	for i := range tail {
+		if tail[i] != expectedTail[i] {
+			t.Errorf("JobLog.Tail() line %d got = %v, expected %v", i, tail[i], expectedTail[i])
+		}
+	}
+}
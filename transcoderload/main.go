@@ -8,20 +8,244 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		if err := runCompare(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var cmd = flag.String("cmd", "ffmpeg", "command")
+	var inputs = flag.String("inputs", "", "comma separated inputs assigned round-robin to the jobs as {{.Input}}")
+	var sourceProtocol = flag.String("source", "", "serve a real-time test stream to each job as {{.Input}} over tcp, udp, http or srt")
+	var sourceFile = flag.String("source-file", "", "file looped by the test source, a test pattern is generated if empty")
+	var sourceCmd = flag.String("source-cmd", "ffmpeg", "ffmpeg binary used by the test source")
+	var sourceSize = flag.String("source-size", "1920x1080", "resolution of the generated test pattern")
+	var sourceFPS = flag.Int("source-fps", 25, "frame rate of the generated test pattern")
+	var progressName = flag.String("progress", "ffmpeg", "how jobs report their progress: ffmpeg (-progress), json (socket in $"+ProgressSocketEnv+") or regex (job output)")
+	var progressRegex = flag.String("progress-regex", "", "regex: pattern matching progress lines with named groups speed or time and optionally fps, frame, drop and dup")
+	var monitor = flag.String("monitor", "", "validate the output each job writes to {{.Output}}, received over udp, tcp or pipe")
+	var maxDelay = flag.Duration("max-delay", 2*time.Second, "monitor: growth of the output delay or gap in the output above which a job fails")
+	var maxDiscontinuities = flag.Int("max-discontinuities", 0, "monitor: continuity errors and timestamp jumps a job may have before it fails")
+	var logDir = flag.String("log-dir", "", "write the output of each job to <log-dir>/<job>.log")
+	var pin = flag.String("pin", "none", "pin each job to its own cores: none, cores or numa (cores within a NUMA node, alternating between nodes)")
+	var pinCores = flag.Int("pin-cores", 1, "number of cores each job is pinned to, 0 for a whole NUMA node")
+	var cgroup = flag.String("cgroup", "", "cgroup v2 directory to place each job in its own cgroup in, e.g. /sys/fs/cgroup/transcoderload")
+	var cgroupCPUs = flag.Float64("cgroup-cpus", 0, "cpu limit of each job's cgroup in cores, 0 for no limit")
+	var cgroupMemory = flag.String("cgroup-memory", "", "memory limit of each job's cgroup, e.g. 2G, empty for no limit")
+	var jobsFile = flag.String("jobs", "", "job definition file with a mix of job templates, replaces the arguments after --")
+	var stallWindow = flag.Int("stall-window", 5, "number of progress reports in the moving speed average")
+	var minSpeed = flag.Float64("min-speed", 1, "moving average speed below which a job is stalling")
+	var maxDropRatio = flag.Float64("max-drop-ratio", 0, "ratio of dropped and duplicated frames above which a job is stalling, 0 to disable")
+	var maxLag = flag.Duration("max-lag", 0, "output time behind wall clock above which a job is stalling, 0 to disable")
+	var minFPS = flag.Float64("min-fps", 0, "target frame rate below which a job is stalling, 0 to disable")
+	var stallLimit = flag.Int("stall-limit", 5, "consecutive stalling progress reports before a job is considered stalled")
+	var warmUp = flag.Duration("warm-up", 0, "time after the launch of a job in which its progress isn't checked for stalls, for encoders with a slow start")
+	var sampleInterval = flag.Duration("sample-interval", 5*time.Second, "interval for sampling cpu and memory usage")
+	var label = flag.String("label", "", "name of the run in the report, e.g. the machine or script tested, shown by compare")
+	var report = flag.String("report", "", "write the final report to <report>.json, <report>.csv and <report>.html")
+	var strategyName = flag.String("strategy", "climb", "search strategy: climb, binary or soak")
+	var baseHold = flag.Duration("base-hold", defaultBaseHold, "hold time of the first cycle, and of the probe cycles of the binary strategy")
+	var holdGrowth = flag.Float64("hold-growth", defaultGrowth, "climb: factor by which the hold time grows with each stall")
+	var stagger = flag.Duration("stagger", time.Second, "delay between launching jobs")
+	var maxHold = flag.Duration("max-hold", 10*time.Minute, "maximum hold time of a cycle, 0 for no limit which requires -confirmations 0 with climb")
+	var confirmations = flag.Int("confirmations", 3, "climb: stop once n jobs passed this often at the maximum hold time and n+1 jobs failed this often, 0 to run until interrupted")
+	var soakJobs = flag.Int("soak-jobs", 0, "soak: number of jobs to run")
+	var soakDuration = flag.Duration("soak-duration", time.Hour, "soak: duration to run the jobs for")
+	var incremental = flag.Bool("incremental", false, "on a stall only stop the stalled job and keep the others running")
+	var preflight = flag.Duration("preflight", 10*time.Second, "run a single job for this long before the search to check that it reports its progress, 0 to skip")
+	var listen = flag.String("listen", "", "address to serve the live status on, e.g. :9100")
+	var budget = flag.Duration("budget", 0, "total time budget of the run, 0 for no limit")
+	var stateFile = flag.String("state", "", "save the progress of the search to this file after each cycle")
+	var resume = flag.Bool("resume", false, "continue the run saved in the -state file, starts a new run if there is none")
+	var config = flag.String("config", "", "json file with option values, e.g. {\"base-hold\": \"1m\"}, options on the command line take precedence")
 	flag.Parse()
 
+	if *config != "" {
+		if err := LoadConfig(flag.CommandLine, *config); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	templates := []*Template{{
+		Name:   "ffmpeg",
+		Cmd:    *cmd,
+		Args:   flag.Args(),
+		Weight: 1,
+	}}
+	if *jobsFile != "" {
+		if flag.NArg() > 0 {
+			log.Fatal("-jobs can't be combined with arguments after --")
+		}
+		var err error
+		templates, err = LoadTemplates(*jobsFile, *cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if err := templates[0].parse(); err != nil {
+		log.Fatal(err)
+	}
+
+	// without inputs {{.Input}} would silently expand to nothing
+	if len(ParseInputs(*inputs)) == 0 && *sourceProtocol == "" {
+		for _, t := range templates {
+			if t.UsesInput() {
+				log.Fatalf("template '%v' uses {{.Input}}, but neither -inputs nor -source is given", t.Name)
+			}
+		}
+	}
+
+	progress, err := ParseProgressSource(*progressName, *progressRegex)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var monitorConfig *MonitorConfig
+	if *monitor != "" {
+		if *monitor != "udp" && *monitor != "tcp" && *monitor != "pipe" {
+			log.Fatalf("unknown output monitor protocol '%v'", *monitor)
+		}
+		monitorConfig = &MonitorConfig{
+			protocol:           *monitor,
+			maxDelay:           *maxDelay,
+			maxDiscontinuities: *maxDiscontinuities,
+			warmUp:             *warmUp,
+		}
+	}
+
+	strategyConfig := &StrategyConfig{
+		baseHold:      *baseHold,
+		growth:        *holdGrowth,
+		maxHold:       *maxHold,
+		confirmations: *confirmations,
+		soakJobs:      *soakJobs,
+		soakDuration:  *soakDuration,
+	}
+	strategy, err := ParseStrategy(*strategyName, strategyConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var resumeState *RunState
+	if *resume {
+		if *stateFile == "" {
+			log.Fatal("-resume requires -state")
+		}
+		resumeState, err = LoadRunState(*stateFile)
+		if os.IsNotExist(err) {
+			log.Printf("No saved state in %v, starting a new run", *stateFile)
+		} else if err != nil {
+			log.Fatal(err)
+		} else if err := resumeState.Apply(templates, strategy, strategyConfig); err != nil {
+			log.Fatal("Can't resume: ", err)
+		}
+		if resumeState != nil && *label == "" {
+			*label = resumeState.Label
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	dirname, err := ioutil.TempDir(os.TempDir(), "*")
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *logDir != "" {
+		if err := os.MkdirAll(*logDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var source *Source
+	if *sourceProtocol != "" {
+		if *inputs != "" {
+			log.Fatal("-source can't be combined with -inputs")
+		}
+		source, err = NewSource(&SourceConfig{
+			protocol: *sourceProtocol,
+			file:     *sourceFile,
+			cmd:      *sourceCmd,
+			size:     *sourceSize,
+			fps:      *sourceFPS,
+		}, dirname)
+		if err != nil {
+			os.RemoveAll(dirname)
+			log.Fatal(err)
+		}
+	}
+
+	var memoryLimit uint64
+	if *cgroupMemory != "" {
+		memoryLimit, err = parseBytes(*cgroupMemory)
+		if err != nil {
+			os.RemoveAll(dirname)
+			log.Fatal(err)
+		}
+	}
+	isolation, err := NewIsolation(&IsolationConfig{
+		pin:          *pin,
+		pinCores:     *pinCores,
+		cgroup:       *cgroup,
+		cgroupCPUs:   *cgroupCPUs,
+		cgroupMemory: memoryLimit,
+	})
+	if err != nil {
+		os.RemoveAll(dirname)
+		log.Fatal(err)
+	}
+
 	// start running jobs
-	r := NewRunner(ctx, dirname, *cmd)
+	r := NewRunner(ctx, &RunnerConfig{
+		dirname:        dirname,
+		templates:      templates,
+		inputs:         ParseInputs(*inputs),
+		source:         source,
+		isolation:      isolation,
+		logDir:         *logDir,
+		progress:       progress,
+		monitor:        monitorConfig,
+		sampleInterval: *sampleInterval,
+		report:         *report,
+		label:          *label,
+		stateFile:      *stateFile,
+		resume:         resumeState,
+		budget:         *budget,
+		incremental:    *incremental,
+		preflight:      *preflight,
+		strategy:       strategy,
+		search:         strategyConfig,
+		stagger:        *stagger,
+		stall: &StallConfig{
+			Window:       *stallWindow,
+			MinSpeed:     *minSpeed,
+			MaxDropRatio: *maxDropRatio,
+			MaxLag:       *maxLag,
+			MinFPS:       *minFPS,
+			Limit:        *stallLimit,
+			WarmUp:       *warmUp,
+		},
+	})
+
+	// stops the run and removes the isolation and the temporary files, also before exiting on errors
+	cleanup := func() {
+		cancel()
+		r.Wait()
+		isolation.Close()
+		os.RemoveAll(dirname)
+	}
+
+	if *listen != "" {
+		server, err := NewStatusServer(*listen, r)
+		if err != nil {
+			cleanup()
+			log.Fatal(err)
+		}
+		defer server.Close()
+	}
 
 	// signal handling
 	c := make(chan os.Signal, 1)
@@ -37,12 +261,12 @@ func main() {
 			if sig == syscall.SIGHUP {
 				continue
 			}
-		case <-ctx.Done():
+		case <-r.Finished():
+		}
+		cleanup()
+		if err := r.Err(); err != nil {
+			log.Fatal("Run aborted: ", err)
 		}
-		// Cleanup
-		cancel()
-		r.Wait()
-		os.RemoveAll(dirname)
 		return
 	}
 }
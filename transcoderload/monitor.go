@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// timestampJump is the gap in seconds between consecutive timestamps which counts as a discontinuity
+	timestampJump = 1
+	// monitorStartTimeout is how long a job may take to start writing its output
+	monitorStartTimeout = 10 * time.Second
+	// monitorBufferSize is the socket receive buffer, so bursts aren't lost while the monitor is busy
+	monitorBufferSize = 4 << 20
+)
+
+// MonitorConfig configures the validation of the job outputs
+type MonitorConfig struct {
+	protocol           string        // udp, tcp or pipe
+	maxDelay           time.Duration // growth of the output delay and gap in the output above which a job fails
+	maxDiscontinuities int           // discontinuities a job may have before it fails
+	warmUp             time.Duration // added to the time a job may take to start writing its output
+}
+
+// OutputStats describes the output of a job
+type OutputStats struct {
+	Format              string  `json:"format"`
+	Bytes               int64   `json:"bytes"`
+	Bitrate             float64 `json:"bitrate_kbits"`
+	DelaySeconds        float64 `json:"delay_seconds"` // growth of the delay versus the wall clock
+	MaxDelaySeconds     float64 `json:"max_delay_seconds"`
+	ContinuityErrors    int     `json:"continuity_errors"`
+	TimestampJumps      int     `json:"timestamp_jumps"`
+	KeyframeInterval    float64 `json:"keyframe_interval_seconds"`
+	MaxKeyframeInterval float64 `json:"max_keyframe_interval_seconds"`
+}
+
+// OutputMonitor receives the output of a single job and checks that it is usable:
+// continuous, with steady timestamps and without growing delay
+type OutputMonitor struct {
+	config  *MonitorConfig
+	url     string
+	closer  io.Closer
+	mutex   sync.Mutex
+	parser  io.Writer
+	stats   OutputStats
+	started time.Time
+	first   time.Time // first data received
+	last    time.Time // last data received
+	now     time.Time // arrival time of the data being parsed
+
+	// reference point for the delay
+	refWall  time.Time
+	refMedia float64
+
+	lastKeyframe float64
+	hasKeyframe  bool
+	reset        int // discontinuities before the last reset
+
+	receive  func(buf []byte) (int, error) // reads from the udp socket or the pipe
+	listener net.Listener                  // accepts the tcp connections
+}
+
+// NewOutputMonitor creates the sink a job writes its output to, dir is the temporary directory of the job
+func NewOutputMonitor(config *MonitorConfig, dir string) (*OutputMonitor, error) {
+	m := &OutputMonitor{
+		config:  config,
+		started: time.Now(),
+	}
+	switch config.protocol {
+	case "udp":
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return nil, err
+		}
+		conn.SetReadBuffer(monitorBufferSize)
+		m.url = "udp://" + conn.LocalAddr().String() + "?pkt_size=" + strconv.Itoa(7*tsPacketSize)
+		m.closer = conn
+		m.receive = conn.Read
+	case "tcp":
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		m.url = "tcp://" + ln.Addr().String()
+		m.closer = ln
+		m.listener = ln
+	case "pipe":
+		filename := path.Join(dir, "output")
+		if err := syscall.Mkfifo(filename, 0644); err != nil {
+			return nil, err
+		}
+		// opening for reading and writing doesn't block until the job opens the pipe
+		file, err := os.OpenFile(filename, os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		m.url = filename
+		m.closer = file
+		m.receive = file.Read
+	default:
+		return nil, fmt.Errorf("unknown output monitor protocol '%v'", config.protocol)
+	}
+	return m, nil
+}
+
+// URL returns where the job has to write its output to
+func (m *OutputMonitor) URL() string {
+	return m.url
+}
+
+// Close stops receiving
+func (m *OutputMonitor) Close() error {
+	return m.closer.Close()
+}
+
+// Run receives the output until ctx is done
+func (m *OutputMonitor) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		m.Close()
+	}()
+	buf := make([]byte, 64*1024)
+	if m.listener == nil {
+		m.read(m.receive, buf)
+		return
+	}
+	for {
+		// a job may reconnect, the next connection continues the stream
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-done:
+			}
+			conn.Close()
+		}()
+		m.read(conn.Read, buf)
+		close(done)
+	}
+}
+
+func (m *OutputMonitor) read(receive func([]byte) (int, error), buf []byte) {
+	for {
+		n, err := receive(buf)
+		if n > 0 {
+			m.write(buf[:n], time.Now())
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write parses data received at now
+func (m *OutputMonitor) write(data []byte, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.parser == nil {
+		if data[0] == tsSyncByte {
+			m.parser = newTSParser(m)
+			m.stats.Format = "mpegts"
+		} else {
+			m.parser = newFMP4Parser(m)
+			m.stats.Format = "fmp4"
+		}
+		m.first = now
+	}
+	m.now, m.last = now, now
+	m.stats.Bytes += int64(len(data))
+	m.parser.Write(data)
+}
+
+func (m *OutputMonitor) clock(seconds float64) {
+	if m.refWall.IsZero() {
+		m.refWall, m.refMedia = m.now, seconds
+	}
+	delay := m.now.Sub(m.refWall).Seconds() - (seconds - m.refMedia)
+	if delay < 0 {
+		// the delay is measured against the lowest delay seen, which includes the buffering of the job
+		m.refWall, m.refMedia = m.now, seconds
+		delay = 0
+	}
+	m.stats.DelaySeconds = delay
+	if delay > m.stats.MaxDelaySeconds {
+		m.stats.MaxDelaySeconds = delay
+	}
+}
+
+func (m *OutputMonitor) keyframe(seconds float64) {
+	if m.hasKeyframe {
+		interval := seconds - m.lastKeyframe
+		m.stats.KeyframeInterval = interval
+		if interval > m.stats.MaxKeyframeInterval {
+			m.stats.MaxKeyframeInterval = interval
+		}
+	}
+	m.lastKeyframe, m.hasKeyframe = seconds, true
+}
+
+func (m *OutputMonitor) continuityError() {
+	m.stats.ContinuityErrors++
+}
+
+func (m *OutputMonitor) timestampJump() {
+	m.stats.TimestampJumps++
+	// measure the delay from the new timestamps on
+	m.refWall = time.Time{}
+	m.hasKeyframe = false
+}
+
+// Check returns why the output isn't usable at now, empty if it is
+func (m *OutputMonitor) Check(now time.Time) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.first.IsZero() {
+		if waited := now.Sub(m.started); waited > monitorStartTimeout+m.config.warmUp {
+			return fmt.Sprintf("no output after %v", waited.Round(time.Second))
+		}
+		return ""
+	}
+	if gap := now.Sub(m.last); gap > m.config.maxDelay {
+		return fmt.Sprintf("no output for %v", gap.Round(time.Millisecond))
+	}
+	discontinuities := m.stats.ContinuityErrors + m.stats.TimestampJumps - m.reset
+	if discontinuities > m.config.maxDiscontinuities {
+		return fmt.Sprintf("%d output discontinuities (%d continuity errors, %d timestamp jumps)",
+			discontinuities, m.stats.ContinuityErrors, m.stats.TimestampJumps)
+	}
+	if delay := time.Duration(m.stats.DelaySeconds * float64(time.Second)); delay > m.config.maxDelay {
+		return fmt.Sprintf("output delay grew by %v", delay.Round(time.Millisecond))
+	}
+	return ""
+}
+
+// Reset starts over with the delay measurement and the discontinuity count, keeping the totals in the stats
+func (m *OutputMonitor) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.refWall = time.Time{}
+	m.reset = m.stats.ContinuityErrors + m.stats.TimestampJumps
+}
+
+// Stats returns the statistics of the output received so far
+func (m *OutputMonitor) Stats() *OutputStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := m.stats
+	if elapsed := m.last.Sub(m.first).Seconds(); elapsed > 0 {
+		stats.Bitrate = float64(stats.Bytes) * 8 / 1000 / elapsed
+	}
+	return &stats
+}
+
+// logOutputStats logs the output statistics of a job
+func logOutputStats(name string, stats *OutputStats) {
+	log.Printf("%s: output %s %0.0f kbit/s, delay %0.3fs (max %0.3fs), %d continuity errors, %d timestamp jumps, keyframe interval %0.2fs (max %0.2fs)",
+		name, stats.Format, stats.Bitrate, stats.DelaySeconds, stats.MaxDelaySeconds, stats.ContinuityErrors,
+		stats.TimestampJumps, stats.KeyframeInterval, stats.MaxKeyframeInterval)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordedEvents collects the events of a stream parser
+type recordedEvents struct {
+	clocks           []float64
+	keyframes        []float64
+	continuityErrors int
+	timestampJumps   int
+}
+
+func (e *recordedEvents) clock(seconds float64) {
+	e.clocks = append(e.clocks, seconds)
+}
+
+func (e *recordedEvents) keyframe(seconds float64) {
+	e.keyframes = append(e.keyframes, seconds)
+}
+
+func (e *recordedEvents) continuityError() {
+	e.continuityErrors++
+}
+
+func (e *recordedEvents) timestampJump() {
+	e.timestampJumps++
+}
+
+// tsPacket builds a packet with the payload padded by an adaptation field
+func tsPacket(pid int, cc int, pusi bool, randomAccess bool, payload []byte) []byte {
+	pkt := make([]byte, 4, tsPacketSize)
+	pkt[0] = tsSyncByte
+	pkt[1] = byte(pid >> 8 & 0x1f)
+	if pusi {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+	stuffing := tsPacketSize - 4 - len(payload)
+	if stuffing > 0 || randomAccess {
+		pkt[3] = 0x30 | byte(cc&0xf)
+		length := stuffing - 1
+		pkt = append(pkt, byte(length))
+		if length > 0 {
+			flags := byte(0)
+			if randomAccess {
+				flags = 0x40
+			}
+			pkt = append(pkt, flags)
+			for i := 1; i < length; i++ {
+				pkt = append(pkt, 0xff)
+			}
+		}
+	} else {
+		pkt[3] = 0x10 | byte(cc&0xf)
+	}
+	return append(pkt, payload...)
+}
+
+// psi builds a PSI section payload with a dummy CRC
+func psi(tableID byte, body []byte) []byte {
+	length := 5 + len(body) + 4
+	s := []byte{0, tableID, 0xb0 | byte(length>>8), byte(length), 0, 1, 0xc1, 0, 0}
+	s = append(s, body...)
+	return append(s, 0, 0, 0, 0)
+}
+
+// pes builds a PES header with a DTS in 90 kHz units
+func pes(dts int64) []byte {
+	ts := func(prefix byte, v int64) []byte {
+		return []byte{prefix<<4 | byte(v>>29&0xe) | 1, byte(v >> 22), byte(v>>14) | 1, byte(v >> 7), byte(v<<1) | 1}
+	}
+	h := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0xc0, 10}
+	h = append(h, ts(3, dts+3600)...)
+	return append(h, ts(1, dts)...)
+}
+
+type tsFrame struct {
+	dts      int64
+	keyframe bool
+	cc       int
+}
+
+func tsStream(frames []tsFrame) []byte {
+	var stream []byte
+	stream = append(stream, tsPacket(0, 0, true, false, psi(0x00, []byte{0, 1, 0xe1, 0x00}))...)
+	stream = append(stream, tsPacket(0x100, 0, true, false, psi(0x02, []byte{
+		0xe1, 0x01, 0xf0, 0x00, // PCR PID, no program info
+		0x0f, 0xe1, 0x02, 0xf0, 0x00, // AAC audio
+		0x1b, 0xe1, 0x01, 0xf0, 0x00, // H.264 video
+	}))...)
+	for _, f := range frames {
+		stream = append(stream, tsPacket(0x101, f.cc, true, f.keyframe, pes(f.dts))...)
+	}
+	return stream
+}
+
+func Test_tsParser(t *testing.T) {
+	tests := []struct {
+		name             string
+		frames           []tsFrame
+		keyframes        []float64
+		continuityErrors int
+		timestampJumps   int
+	}{
+		{"continuous", []tsFrame{{0, true, 0}, {3600, false, 1}, {7200, false, 2}, {90000, true, 3}}, []float64{0, 1}, 0, 0},
+		{"lost packet", []tsFrame{{0, true, 0}, {3600, false, 1}, {7200, false, 3}}, []float64{0}, 1, 0},
+		{"duplicate packet", []tsFrame{{0, true, 0}, {3600, false, 1}, {3600, false, 1}}, []float64{0}, 0, 0},
+		{"counter wraps", []tsFrame{{0, true, 14}, {3600, false, 15}, {7200, false, 0}}, []float64{0}, 0, 0},
+		{"backwards", []tsFrame{{90000, true, 0}, {3600, false, 1}}, []float64{1}, 0, 1},
+		{"jump", []tsFrame{{0, true, 0}, {5 * 90000, false, 1}}, []float64{0}, 0, 1},
+		{"wrap around", []tsFrame{{tsWrap - 3600, true, 0}, {0, false, 1}}, []float64{float64(tsWrap-3600) / 90000}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := &recordedEvents{}
+			parser := newTSParser(events)
+			stream := tsStream(tt.frames)
+			// split the stream into chunks not aligned to packets
+			for len(stream) > 0 {
+				n := 100
+				if n > len(stream) {
+					n = len(stream)
+				}
+				parser.Write(stream[:n])
+				stream = stream[n:]
+			}
+			if len(events.clocks) != len(tt.frames) {
+				t.Errorf("got %d clocks, expected %d", len(events.clocks), len(tt.frames))
+			}
+			if len(events.keyframes) != len(tt.keyframes) {
+				t.Fatalf("keyframes got = %v, expected %v", events.keyframes, tt.keyframes)
+			}
+			for i := range tt.keyframes {
+				if events.keyframes[i] != tt.keyframes[i] {
+					t.Errorf("keyframes got = %v, expected %v", events.keyframes, tt.keyframes)
+				}
+			}
+			if events.continuityErrors != tt.continuityErrors || events.timestampJumps != tt.timestampJumps {
+				t.Errorf("got %d continuity errors, %d timestamp jumps, expected %d, %d",
+					events.continuityErrors, events.timestampJumps, tt.continuityErrors, tt.timestampJumps)
+			}
+		})
+	}
+}
+
+func box(boxType string, content ...[]byte) []byte {
+	size := 8
+	for _, c := range content {
+		size += len(c)
+	}
+	b := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(b, uint32(size))
+	copy(b[4:], boxType)
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func u32(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[4*i:], v)
+	}
+	return b
+}
+
+func fmp4Init() []byte {
+	trak := func(id uint32, timescale uint32, handler string) []byte {
+		return box("trak",
+			box("tkhd", u32(0, 0, 0, id)),
+			box("mdia",
+				box("mdhd", u32(0, 0, 0, timescale)),
+				box("hdlr", u32(0, 0), []byte(handler))))
+	}
+	return append(box("ftyp", []byte("isom")), box("moov", trak(1, 48000, "soun"), trak(2, 90000, "vide"))...)
+}
+
+// fragment builds a moof and mdat with samples of 3600 ticks, the first sample flagged as keyframe or not
+func fragment(track uint32, decodeTime uint32, samples int, keyframe bool) []byte {
+	flags := uint32(sampleNonSync)
+	if keyframe {
+		flags = 0
+	}
+	trun := u32(0x000104, uint32(samples), flags)
+	for i := 0; i < samples; i++ {
+		trun = append(trun, u32(3600)...)
+	}
+	moof := box("moof", box("traf",
+		box("tfhd", u32(0, track)),
+		box("tfdt", u32(0, decodeTime)),
+		box("trun", trun)))
+	return append(moof, box("mdat", make([]byte, 100))...)
+}
+
+func Test_fmp4Parser(t *testing.T) {
+	tests := []struct {
+		name           string
+		fragments      [][]byte
+		clocks         []float64
+		keyframes      int
+		timestampJumps int
+	}{
+		{"continuous", [][]byte{fragment(2, 0, 25, true), fragment(1, 0, 10, true), fragment(2, 90000, 25, true)}, []float64{0, 1}, 2, 0},
+		{"gap", [][]byte{fragment(2, 0, 25, true), fragment(2, 4*90000, 25, false)}, []float64{0, 4}, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := &recordedEvents{}
+			parser := newFMP4Parser(events)
+			stream := fmp4Init()
+			for _, f := range tt.fragments {
+				stream = append(stream, f...)
+			}
+			for _, b := range stream {
+				parser.Write([]byte{b})
+			}
+			if len(events.clocks) != len(tt.clocks) {
+				t.Fatalf("clocks got = %v, expected %v", events.clocks, tt.clocks)
+			}
+			for i := range tt.clocks {
+				if events.clocks[i] != tt.clocks[i] {
+					t.Errorf("clocks got = %v, expected %v", events.clocks, tt.clocks)
+				}
+			}
+			if len(events.keyframes) != tt.keyframes || events.timestampJumps != tt.timestampJumps {
+				t.Errorf("got keyframes %v, %d timestamp jumps, expected %d, %d",
+					events.keyframes, events.timestampJumps, tt.keyframes, tt.timestampJumps)
+			}
+		})
+	}
+}
+
+func TestOutputMonitor_fragmentWithoutInit(t *testing.T) {
+	start := time.Now()
+	m := &OutputMonitor{config: &MonitorConfig{maxDelay: 2 * time.Second}, started: start}
+	// track 0 matches the clock track before any moov was parsed
+	m.write(fragment(0, 0, 25, true), start)
+	m.write(fragment(2, 90000, 25, true), start.Add(time.Second))
+	if !m.refWall.IsZero() || m.stats.Format != "fmp4" {
+		t.Errorf("write() got clock at %v, format %v, expected no clock and fmp4", m.refWall, m.stats.Format)
+	}
+}
+
+func TestOutputMonitor_Check(t *testing.T) {
+	config := &MonitorConfig{maxDelay: 2 * time.Second}
+	start := time.Now()
+	frames := make([]tsFrame, 0)
+	for i := 0; i < 100; i++ {
+		frames = append(frames, tsFrame{int64(i) * 3600, i%25 == 0, i})
+	}
+	stream := tsStream(frames)
+
+	tests := []struct {
+		name     string
+		interval time.Duration // wall clock time between frames
+		checkAt  time.Duration
+		expected string
+	}{
+		{"realtime", 40 * time.Millisecond, 4 * time.Second, ""},
+		{"too slow", 80 * time.Millisecond, 8 * time.Second, "output delay"},
+		{"stopped", 40 * time.Millisecond, 10 * time.Second, "no output for"},
+		{"no output", 0, 11 * time.Second, "no output after"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &OutputMonitor{config: config, started: start}
+			if tt.interval > 0 {
+				m.write(stream[:2*tsPacketSize], start)
+				for i := range frames {
+					offset := (2 + i) * tsPacketSize
+					m.write(stream[offset:offset+tsPacketSize], start.Add(time.Duration(i)*tt.interval))
+				}
+			}
+			reason := m.Check(start.Add(tt.checkAt))
+			if (tt.expected == "") != (reason == "") || !strings.HasPrefix(reason, tt.expected) {
+				t.Errorf("Check() got = %q, expected %q", reason, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsNullPID    = 0x1fff
+
+	// timestamps are 33 bit values in 90 kHz units
+	tsClockRate = 90000
+	tsWrap      = int64(1) << 33
+)
+
+// streamEvents receives the timing information found in the output stream
+type streamEvents interface {
+	// clock reports the media time in seconds of the data received last
+	clock(seconds float64)
+	// keyframe reports a keyframe at the media time in seconds
+	keyframe(seconds float64)
+	// continuityError reports lost or duplicated data
+	continuityError()
+	// timestampJump reports timestamps going backwards or jumping ahead
+	timestampJump()
+}
+
+// tsParser follows an MPEG-TS stream.
+// It checks the continuity counters of all PIDs and the decode timestamps and random access points of the
+// first video stream of the first program, which also serves as the media clock.
+// PSI sections are expected to fit into a single packet, which is the case for single program streams.
+type tsParser struct {
+	events   streamEvents
+	buf      []byte
+	pmtPID   int
+	clockPID int
+	video    bool // the clock PID is a video stream
+	cc       map[int]int
+	lastDTS  int64
+	unwrap   int64 // multiples of tsWrap added to the timestamps
+	hasDTS   bool
+}
+
+func newTSParser(events streamEvents) *tsParser {
+	return &tsParser{
+		events:   events,
+		pmtPID:   -1,
+		clockPID: -1,
+		cc:       make(map[int]int),
+	}
+}
+
+// Write parses the packets in p, data not aligned to packets is skipped
+func (t *tsParser) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	for len(t.buf) >= tsPacketSize {
+		if t.buf[0] != tsSyncByte {
+			// resynchronize
+			i := 1
+			for i < len(t.buf) && t.buf[i] != tsSyncByte {
+				i++
+			}
+			t.buf = t.buf[i:]
+			continue
+		}
+		t.packet(t.buf[:tsPacketSize])
+		t.buf = t.buf[tsPacketSize:]
+	}
+	// keep the remainder for the next write without growing the buffer
+	t.buf = append([]byte(nil), t.buf...)
+	return len(p), nil
+}
+
+func (t *tsParser) packet(pkt []byte) {
+	pusi := pkt[1]&0x40 != 0
+	pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+	control := (pkt[3] >> 4) & 0x3
+	cc := int(pkt[3] & 0xf)
+	if pid == tsNullPID {
+		return
+	}
+
+	payload := pkt[4:]
+	discontinuity, randomAccess := false, false
+	if control&0x2 != 0 {
+		length := int(pkt[4])
+		if length > 0 && length <= 183 {
+			discontinuity = pkt[5]&0x80 != 0
+			randomAccess = pkt[5]&0x40 != 0
+		}
+		if 5+length > len(pkt) {
+			return
+		}
+		payload = pkt[5+length:]
+	}
+	if control&0x1 == 0 {
+		// the counter only increases with packets carrying payload
+		return
+	}
+
+	if last, ok := t.cc[pid]; ok && !discontinuity {
+		// a single duplicate packet is allowed
+		if cc != (last+1)&0xf && cc != last {
+			t.events.continuityError()
+		}
+	}
+	t.cc[pid] = cc
+
+	switch {
+	case pid == 0 && pusi:
+		t.parsePAT(payload)
+	case pid == t.pmtPID && pusi:
+		t.parsePMT(payload)
+	case pid == t.clockPID && pusi:
+		t.parsePES(payload, randomAccess)
+	}
+}
+
+// section returns the PSI section starting in the payload
+func section(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) {
+		return nil
+	}
+	s := payload[start:]
+	length := int(s[1]&0xf)<<8 | int(s[2])
+	if 3+length > len(s) || length < 9 {
+		return nil
+	}
+	// without the CRC
+	return s[:3+length-4]
+}
+
+func (t *tsParser) parsePAT(payload []byte) {
+	s := section(payload)
+	if s == nil || s[0] != 0x00 {
+		return
+	}
+	for i := 8; i+4 <= len(s); i += 4 {
+		program := int(s[i])<<8 | int(s[i+1])
+		if program != 0 {
+			t.pmtPID = int(s[i+2]&0x1f)<<8 | int(s[i+3])
+			return
+		}
+	}
+}
+
+func (t *tsParser) parsePMT(payload []byte) {
+	s := section(payload)
+	if s == nil || s[0] != 0x02 || len(s) < 12 {
+		return
+	}
+	infoLength := int(s[10]&0xf)<<8 | int(s[11])
+	first := -1
+	for i := 12 + infoLength; i+5 <= len(s); {
+		streamType := s[i]
+		pid := int(s[i+1]&0x1f)<<8 | int(s[i+2])
+		esInfoLength := int(s[i+3]&0xf)<<8 | int(s[i+4])
+		if isVideoStreamType(streamType) {
+			t.setClockPID(pid, true)
+			return
+		}
+		if first < 0 {
+			first = pid
+		}
+		i += 5 + esInfoLength
+	}
+	if first >= 0 {
+		t.setClockPID(first, false)
+	}
+}
+
+func (t *tsParser) setClockPID(pid int, video bool) {
+	if pid != t.clockPID {
+		t.clockPID, t.video, t.hasDTS = pid, video, false
+	}
+}
+
+// isVideoStreamType returns whether the PMT stream type is MPEG-1/2, MPEG-4 part 2, H.264, H.265 or H.266 video
+func isVideoStreamType(streamType byte) bool {
+	switch streamType {
+	case 0x01, 0x02, 0x10, 0x1b, 0x24, 0x33:
+		return true
+	}
+	return false
+}
+
+// parsePES reads the decode timestamp from the header of a PES packet
+func (t *tsParser) parsePES(payload []byte, randomAccess bool) {
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return
+	}
+	flags := payload[7] >> 6
+	var ts int64
+	switch flags {
+	case 0x2:
+		ts = pesTimestamp(payload[9:14])
+	case 0x3:
+		if len(payload) < 19 {
+			return
+		}
+		ts = pesTimestamp(payload[14:19])
+	default:
+		return
+	}
+
+	ts += t.unwrap
+	if t.hasDTS {
+		delta := ts - t.lastDTS
+		if delta < -tsWrap/2 {
+			// wrapped around
+			t.unwrap += tsWrap
+			ts += tsWrap
+			delta += tsWrap
+		}
+		if delta < 0 || delta > timestampJump*tsClockRate {
+			t.events.timestampJump()
+		}
+	}
+	t.lastDTS, t.hasDTS = ts, true
+
+	seconds := float64(ts) / tsClockRate
+	t.events.clock(seconds)
+	if randomAccess && t.video {
+		t.events.keyframe(seconds)
+	}
+}
+
+// pesTimestamp decodes a 33 bit PTS or DTS
+func pesTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x7)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// preflight runs a single job of each template for the preflight duration
+// and returns an error if it doesn't report its progress or exits
+func (r *Runner) preflight(ctx context.Context) error {
+	if r.config.preflight <= 0 {
+		return nil
+	}
+	for _, template := range r.config.templates {
+		if err := r.preflightTemplate(ctx, template); err != nil {
+			return fmt.Errorf("preflight of '%v' failed: %v", template.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) preflightTemplate(ctx context.Context, template *Template) error {
+	name := template.Name + "-preflight"
+	vars := &JobVars{
+		Index:    1,
+		Name:     name,
+		Template: template.Name,
+	}
+	if len(r.config.inputs) > 0 {
+		vars.Input = r.config.inputs[0]
+	}
+	log.Printf("Preflight: running %v for %v", name, r.config.preflight)
+	notify := make(chan *Job, 1)
+	job, err := r.startJob(ctx, vars, template, notify)
+	if err != nil {
+		return err
+	}
+	defer job.Stop()
+
+	timer := time.NewTimer(r.config.preflight)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-notify:
+		exit := job.Exit()
+		if exit.Reason == ExitStall {
+			// the job runs, it's just too slow for this machine
+			log.Printf("Preflight: %v stalled (%v), the machine may not be able to run a single job", name, exit.Detail)
+			return nil
+		}
+		hint := ""
+		if exit.Reason == ExitNoProgress {
+			hint = ", " + r.config.progress.Hint()
+		}
+		return fmt.Errorf("%v exited after %v: %v%v%v", name, time.Since(job.Launched).Round(time.Millisecond), exit, hint, preflightOutput(job))
+	case <-timer.C:
+	}
+
+	if !job.Connected() {
+		return fmt.Errorf("%v didn't report %v progress within %v, %v%v",
+			name, r.config.progress, r.config.preflight, r.config.progress.Hint(), preflightOutput(job))
+	}
+	p := job.Progress()
+	if p == nil || p.Speed <= 0 {
+		return fmt.Errorf("%v didn't report its speed within %v, is the input available?%v",
+			name, r.config.preflight, preflightOutput(job))
+	}
+	log.Printf("Preflight: %v running at speed %0.3fx", name, p.Speed)
+	r.reported[template] = true
+	return nil
+}
+
+// preflightOutput returns the last output lines of the job for an error message
+func preflightOutput(job *Job) string {
+	lines := job.log.Tail(exitOutputLines)
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n  " + strings.Join(lines, "\n  ")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunner_preflightTemplate(t *testing.T) {
+	progress, err := NewRegexProgress(`speed=(?P<speed>\S+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		script   string
+		expected string
+	}{
+		{"running", "while true; do echo speed=1.5x; sleep 0.1; done", ""},
+		{"immediate exit", "echo 'Unrecognized option'; exit 1", "ffmpeg-preflight exited after"},
+		{"no progress", "sleep 10", "ffmpeg-preflight didn't report regex progress"},
+		{"no speed", "while true; do echo speed=N/A; sleep 0.1; done", "ffmpeg-preflight didn't report its speed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "preflight")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			template := &Template{Name: "ffmpeg", Cmd: "sh", Args: []string{"-c", tt.script}, Weight: 1}
+			if err := template.parse(); err != nil {
+				t.Fatal(err)
+			}
+			r := &Runner{config: &RunnerConfig{
+				dirname:   dir,
+				templates: []*Template{template},
+				progress:  progress,
+				// don't let the missing speed end the job as a stall
+				stall:     &StallConfig{Window: 1, MinSpeed: 1, Limit: 100},
+				preflight: 500 * time.Millisecond,
+			}, reported: make(map[*Template]bool)}
+			err = r.preflightTemplate(context.Background(), template)
+			if (tt.expected == "") != (err == nil) || (err != nil && !strings.HasPrefix(err.Error(), tt.expected)) {
+				t.Errorf("preflightTemplate() error = %v, expected %q", err, tt.expected)
+			}
+			// later jobs exiting without progress aren't taken for a broken command line
+			if r.reported[template] != (err == nil) {
+				t.Errorf("preflightTemplate() reported = %v, expected %v", r.reported[template], err == nil)
+			}
+		})
+	}
+}
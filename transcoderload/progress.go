@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Progress is a single status block reported by ffmpeg -progress
+type Progress struct {
+	Frame      int64
+	FPS        float64
+	Bitrate    float64 // kbit/s, 0 if unknown
+	TotalSize  int64   // bytes
+	OutTimeUs  int64   // output timestamp in microseconds
+	DupFrames  int64
+	DropFrames int64
+	Speed      float64 // realtime factor, 0 if unknown
+	SpeedValid bool    // false while ffmpeg reports N/A
+	End        bool    // last block before ffmpeg exits
+}
+
+// ProgressReader parses the ffmpeg progress protocol.
+// The protocol consists of key=value lines, each block is terminated by a progress=continue/end line.
+type ProgressReader struct {
+	scanner *bufio.Scanner
+}
+
+// NewProgressReader creates a progress parser on a stream
+func NewProgressReader(r io.Reader) *ProgressReader {
+	return &ProgressReader{
+		scanner: bufio.NewScanner(r),
+	}
+}
+
+// Next returns the next complete progress block.
+// Lines split over multiple reads are reassembled by the scanner.
+func (pr *ProgressReader) Next() (*Progress, error) {
+	p := &Progress{}
+	for pr.scanner.Scan() {
+		line := strings.TrimSpace(pr.scanner.Text())
+		if line == "" {
+			continue
+		}
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid progress '%v'", line)
+		}
+		key, value := split[0], strings.TrimSpace(split[1])
+		if key == "progress" {
+			p.End = value == "end"
+			return p, nil
+		}
+		p.set(key, value)
+	}
+	if err := pr.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// set parses a single progress value.
+// Unknown keys are ignored, unparseable values (like N/A) are left at zero.
+func (p *Progress) set(key string, value string) {
+	switch key {
+	case "frame":
+		p.Frame, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		p.FPS, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		p.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+	case "total_size":
+		p.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+	case "out_time_us":
+		p.OutTimeUs, _ = strconv.ParseInt(value, 10, 64)
+	case "dup_frames":
+		p.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "drop_frames":
+		p.DropFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "speed":
+		speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		p.Speed, p.SpeedValid = speed, err == nil
+	}
+}
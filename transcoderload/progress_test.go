@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const progressSample = `frame=250
+fps=25.02
+stream_0_0_q=28.0
+bitrate=1254.3kbits/s
+total_size=1572864
+out_time_us=10000000
+out_time_ms=10000000
+out_time=00:00:10.000000
+dup_frames=2
+drop_frames=1
+speed=1.01x
+progress=continue
+frame=260
+fps=N/A
+bitrate=N/A
+total_size=N/A
+out_time_us=10400000
+speed=N/A
+progress=end
+`
+
+func TestProgressReader_Next(t *testing.T) {
+	// deliver one byte per read to split lines
+	reader := NewProgressReader(iotest.OneByteReader(strings.NewReader(progressSample)))
+
+	p, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Progress{
+		Frame:      250,
+		FPS:        25.02,
+		Bitrate:    1254.3,
+		TotalSize:  1572864,
+		OutTimeUs:  10000000,
+		DupFrames:  2,
+		DropFrames: 1,
+		Speed:      1.01,
+		SpeedValid: true,
+	}
+	if *p != expected {
+		t.Errorf("ProgressReader.Next() got = %+v, expected %+v", *p, expected)
+	}
+
+	p, err = reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Frame != 260 || p.SpeedValid || p.FPS != 0 || !p.End {
+		t.Errorf("ProgressReader.Next() got = %+v, expected N/A values and end", *p)
+	}
+
+	if _, err = reader.Next(); err != io.EOF {
+		t.Errorf("ProgressReader.Next() got = %v, expected EOF", err)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProgressSocketEnv is the environment variable holding the socket json progress is reported to
+const ProgressSocketEnv = "TRANSCODERLOAD_PROGRESS"
+
+// minSpeedInterval is the minimum wall clock time speed is calculated over when it isn't reported directly
+const minSpeedInterval = time.Second
+
+// ProgressParser returns the progress reported by a job block by block
+type ProgressParser interface {
+	Next() (*Progress, error)
+}
+
+// ProgressSource is the way a job reports its progress
+type ProgressSource interface {
+	// Socket returns whether progress is reported to a unix socket instead of the job output
+	Socket() bool
+	// Prepare returns the arguments put in front of the job arguments and the environment variables
+	// making cmd report its progress to socket
+	Prepare(cmd string, socket string) (args []string, env []string)
+	// NewParser parses the progress read from the socket or the job output
+	NewParser(r io.Reader) ProgressParser
+	// Hint explains how the command has to report its progress
+	Hint() string
+	String() string
+}
+
+// ParseProgressSource returns the progress source by name, pattern is used by the regex source
+func ParseProgressSource(name string, pattern string) (ProgressSource, error) {
+	switch name {
+	case "ffmpeg":
+		return FFmpegProgress{}, nil
+	case "json":
+		return JSONProgress{}, nil
+	case "regex":
+		return NewRegexProgress(pattern)
+	}
+	return nil, fmt.Errorf("unknown progress source '%v'", name)
+}
+
+// FFmpegProgress reads the ffmpeg -progress protocol from a unix socket
+type FFmpegProgress struct{}
+
+func (FFmpegProgress) Socket() bool {
+	return true
+}
+
+func (FFmpegProgress) Prepare(cmd string, socket string) ([]string, []string) {
+	args := []string{"-progress", "unix://" + socket}
+	if cmd == "ffmpeg" {
+		args = append(args, "-v", "warning")
+	}
+	return args, nil
+}
+
+func (FFmpegProgress) NewParser(r io.Reader) ProgressParser {
+	return NewProgressReader(r)
+}
+
+func (FFmpegProgress) Hint() string {
+	return "if -cmd is a wrapper script, it has to pass the -progress option through to ffmpeg"
+}
+
+func (FFmpegProgress) String() string {
+	return "ffmpeg"
+}
+
+// JSONProgress reads newline separated json objects from the unix socket passed in $TRANSCODERLOAD_PROGRESS
+type JSONProgress struct{}
+
+// jsonReport is a single progress report of the json protocol
+type jsonReport struct {
+	Frame      int64    `json:"frame"`
+	FPS        float64  `json:"fps"`
+	Bitrate    float64  `json:"bitrate"`
+	TotalSize  int64    `json:"total_size"`
+	OutTimeUs  int64    `json:"out_time_us"`
+	DupFrames  int64    `json:"dup_frames"`
+	DropFrames int64    `json:"drop_frames"`
+	Speed      *float64 `json:"speed"`
+	End        bool     `json:"end"`
+}
+
+func (JSONProgress) Socket() bool {
+	return true
+}
+
+func (JSONProgress) Prepare(cmd string, socket string) ([]string, []string) {
+	return nil, []string{ProgressSocketEnv + "=" + socket}
+}
+
+func (JSONProgress) NewParser(r io.Reader) ProgressParser {
+	return &jsonParser{decoder: json.NewDecoder(r)}
+}
+
+func (JSONProgress) Hint() string {
+	return "the command has to connect to the unix socket in $" + ProgressSocketEnv + " and write a json object per progress report"
+}
+
+func (JSONProgress) String() string {
+	return "json"
+}
+
+type jsonParser struct {
+	decoder *json.Decoder
+}
+
+func (jp *jsonParser) Next() (*Progress, error) {
+	var report jsonReport
+	if err := jp.decoder.Decode(&report); err != nil {
+		if err != io.EOF {
+			err = fmt.Errorf("invalid json progress: %v", err)
+		}
+		return nil, err
+	}
+	p := &Progress{
+		Frame:      report.Frame,
+		FPS:        report.FPS,
+		Bitrate:    report.Bitrate,
+		TotalSize:  report.TotalSize,
+		OutTimeUs:  report.OutTimeUs,
+		DupFrames:  report.DupFrames,
+		DropFrames: report.DropFrames,
+		End:        report.End,
+	}
+	if report.Speed != nil {
+		p.Speed, p.SpeedValid = *report.Speed, true
+	}
+	return p, nil
+}
+
+// RegexProgress parses progress from the output lines of the job.
+// The named groups speed, fps, frame, time, drop and dup of the pattern are read from matching lines,
+// without a speed group the speed is calculated from the progress of time over the wall clock.
+type RegexProgress struct {
+	pattern *regexp.Regexp
+}
+
+// NewRegexProgress compiles the pattern and checks that it reports the speed
+func NewRegexProgress(pattern string) (*RegexProgress, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("the regex progress source requires -progress-regex")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		groups[name] = true
+	}
+	if !groups["speed"] && !groups["time"] {
+		return nil, fmt.Errorf("progress regex '%v' needs a named group speed or time, e.g. (?P<speed>[0-9.]+)", pattern)
+	}
+	return &RegexProgress{pattern: re}, nil
+}
+
+func (rp *RegexProgress) Socket() bool {
+	return false
+}
+
+func (rp *RegexProgress) Prepare(cmd string, socket string) ([]string, []string) {
+	return nil, nil
+}
+
+func (rp *RegexProgress) NewParser(r io.Reader) ProgressParser {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxOutputLine)
+	scanner.Split(skipLongLines(maxOutputLine, scanLines))
+	return &regexParser{
+		pattern: rp.pattern,
+		scanner: scanner,
+		now:     time.Now,
+	}
+}
+
+func (rp *RegexProgress) Hint() string {
+	return fmt.Sprintf("no output line matched the progress regex '%v'", rp.pattern)
+}
+
+func (rp *RegexProgress) String() string {
+	return "regex"
+}
+
+type regexParser struct {
+	pattern *regexp.Regexp
+	scanner *bufio.Scanner
+	now     func() time.Time
+
+	// reference point for calculating the speed
+	refTime time.Time
+	refOut  int64
+	speed   float64
+	valid   bool
+}
+
+func (rp *regexParser) Next() (*Progress, error) {
+	for rp.scanner.Scan() {
+		match := rp.pattern.FindStringSubmatch(rp.scanner.Text())
+		if match == nil {
+			continue
+		}
+		p := &Progress{}
+		hasSpeed, hasTime := false, false
+		for i, name := range rp.pattern.SubexpNames() {
+			value := strings.TrimSpace(match[i])
+			switch name {
+			case "speed":
+				speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+				p.Speed, p.SpeedValid = speed, err == nil
+				hasSpeed = true
+			case "fps":
+				p.FPS, _ = strconv.ParseFloat(value, 64)
+			case "frame":
+				p.Frame, _ = strconv.ParseInt(value, 10, 64)
+			case "time":
+				p.OutTimeUs, hasTime = parseTimestamp(value)
+			case "drop":
+				p.DropFrames, _ = strconv.ParseInt(value, 10, 64)
+			case "dup":
+				p.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+		if !hasSpeed && hasTime {
+			rp.calculateSpeed(p)
+			if !p.SpeedValid {
+				// reports without a speed count as stalls, so wait for the first one
+				continue
+			}
+		}
+		return p, nil
+	}
+	if err := rp.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// calculateSpeed sets the speed from the output time passed since the reference point
+func (rp *regexParser) calculateSpeed(p *Progress) {
+	now := rp.now()
+	if rp.refTime.IsZero() {
+		rp.refTime, rp.refOut = now, p.OutTimeUs
+	} else if elapsed := now.Sub(rp.refTime); elapsed >= minSpeedInterval {
+		output := time.Duration(p.OutTimeUs-rp.refOut) * time.Microsecond
+		rp.speed, rp.valid = output.Seconds()/elapsed.Seconds(), true
+		rp.refTime, rp.refOut = now, p.OutTimeUs
+	}
+	p.Speed, p.SpeedValid = rp.speed, rp.valid
+}
+
+// parseTimestamp parses seconds or [hh:]mm:ss[.fraction] to microseconds
+func parseTimestamp(value string) (int64, bool) {
+	var seconds float64
+	for _, part := range strings.Split(value, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		seconds = seconds*60 + v
+	}
+	return int64(seconds * 1e6), true
+}
+
+// maxOutputLine is the longest output line matched against the progress regex
+const maxOutputLine = 64 * 1024
+
+// skipLongLines drops lines longer than maxLine instead of failing the scanner,
+// so a job printing a long line isn't taken for having stopped its progress
+func skipLongLines(maxLine int, split bufio.SplitFunc) bufio.SplitFunc {
+	skipping := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if err != nil {
+			return advance, token, err
+		}
+		if token == nil && advance == 0 && len(data) >= maxLine {
+			// discard the buffered part of the line
+			skipping = true
+			return len(data), nil, nil
+		}
+		if token != nil && skipping {
+			// end of the long line
+			skipping = false
+			return advance, nil, nil
+		}
+		return advance, token, nil
+	}
+}
+
+// scanLines splits output on newlines and carriage returns, progress lines are often terminated by the latter
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
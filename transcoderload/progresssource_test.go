@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONProgress(t *testing.T) {
+	input := `{"speed": 1.02, "fps": 25, "frame": 100, "out_time_us": 4000000}
+{"frame": 110}
+{"end": true}
+`
+	parser := JSONProgress{}.NewParser(strings.NewReader(input))
+	p, err := parser.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Speed != 1.02 || !p.SpeedValid || p.FPS != 25 || p.Frame != 100 || p.OutTimeUs != 4000000 {
+		t.Errorf("Next() got = %+v", p)
+	}
+	if p, _ = parser.Next(); p.SpeedValid {
+		t.Errorf("Next() without speed got = %+v", p)
+	}
+	if p, _ = parser.Next(); !p.End {
+		t.Errorf("Next() got = %+v, expected end", p)
+	}
+	if _, err = parser.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, expected EOF", err)
+	}
+}
+
+func TestRegexProgress(t *testing.T) {
+	source, err := NewRegexProgress(`fps=(?P<fps>[0-9.]+) speed=(?P<speed>\S+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := source.NewParser(strings.NewReader("starting\rfps=25.0 speed=0.98x\rother output\nfps=24.5 speed=N/Ax\n"))
+	p, err := parser.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.FPS != 25 || p.Speed != 0.98 || !p.SpeedValid {
+		t.Errorf("Next() got = %+v", p)
+	}
+	if p, err = parser.Next(); err != nil || p.FPS != 24.5 || p.SpeedValid {
+		t.Errorf("Next() got = %+v, %v", p, err)
+	}
+	if _, err = parser.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, expected EOF", err)
+	}
+}
+
+func TestRegexProgress_longLine(t *testing.T) {
+	source, err := NewRegexProgress(`speed=(?P<speed>\S+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 3*maxOutputLine) + " speed=5x"
+	parser := source.NewParser(strings.NewReader("speed=1x\n" + long + "\nspeed=2x\n"))
+	for _, expected := range []float64{1, 2} {
+		p, err := parser.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if p.Speed != expected {
+			t.Errorf("Next() got speed %v, expected %v", p.Speed, expected)
+		}
+	}
+	if _, err = parser.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, expected EOF", err)
+	}
+}
+
+func TestRegexProgress_time(t *testing.T) {
+	source, err := NewRegexProgress(`\((?P<time>[0-9:.]+)\)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := source.NewParser(strings.NewReader("(00:00:01)\n(00:00:01.5)\n(00:00:03)\n(00:00:04)\n")).(*regexParser)
+	now := time.Unix(0, 0)
+	parser.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	expected := []struct {
+		speed float64
+		valid bool
+	}{{0.5, true}, {1.5, true}, {1, true}}
+	for i, e := range expected {
+		p, err := parser.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Speed != e.speed || p.SpeedValid != e.valid {
+			t.Errorf("report %d: got speed %v (%v), expected %v (%v)", i, p.Speed, p.SpeedValid, e.speed, e.valid)
+		}
+	}
+}
+
+func TestRegexProgress_timeStall(t *testing.T) {
+	source, err := NewRegexProgress(`\((?P<time>[0-9:.]+)\)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ten reports per second, more than the stall limit before the first speed is known
+	var output strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&output, "(%0.1f)\n", float64(i)/10)
+	}
+	parser := source.NewParser(strings.NewReader(output.String())).(*regexParser)
+	start := time.Unix(0, 0)
+	now := start
+	parser.now = func() time.Time {
+		now = now.Add(100 * time.Millisecond)
+		return now
+	}
+	d := NewStallDetector(&StallConfig{Window: 3, MinSpeed: 0.9, Limit: 5})
+	reports := 0
+	for {
+		p, err := parser.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		reports++
+		if reason := d.Check(p, now); reason != "" {
+			t.Fatalf("StallDetector.Check() report %d got = %v, expected no stall", reports, reason)
+		}
+	}
+	if reports != 20 {
+		t.Errorf("Next() got %d reports, expected 20 after the first second", reports)
+	}
+}
+
+func TestParseProgressSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		err     bool
+	}{
+		{"ffmpeg", "", false},
+		{"json", "", false},
+		{"regex", `speed=(?P<speed>\S+)`, false},
+		{"regex", "", true},
+		{"regex", `fps=(?P<fps>\S+)`, true},
+		{"gstreamer", "", true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseProgressSource(tt.name, tt.pattern); (err != nil) != tt.err {
+			t.Errorf("ParseProgressSource(%v, %v) error = %v, expected error %v", tt.name, tt.pattern, err, tt.err)
+		}
+	}
+}
+
+func Test_parseTimestamp(t *testing.T) {
+	tests := map[string]int64{
+		"12.5":        12500000,
+		"01:30":       90000000,
+		"1:00:00.25":  3600250000,
+		"00:00:00.04": 40000,
+	}
+	for value, expected := range tests {
+		if us, ok := parseTimestamp(value); !ok || us != expected {
+			t.Errorf("parseTimestamp(%v) got = %v, expected %v", value, us, expected)
+		}
+	}
+}
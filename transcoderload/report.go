@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ReportEntry contains the results for a single job count
+type ReportEntry struct {
+	Jobs          int            `json:"jobs"`
+	Passes        int            `json:"passes"`
+	Fails         int            `json:"fails"`
+	TestedSeconds float64        `json:"tested_seconds"`
+	Confidence    float64        `json:"confidence"`
+	Resources     *ResourceStats `json:"resources,omitempty"`
+	Exits         []*ExitEvent   `json:"exits,omitempty"`
+}
+
+// ExitEvent describes a job which stalled or exited during a cycle
+type ExitEvent struct {
+	Job          string         `json:"job"`
+	Template     string         `json:"template"`
+	AfterSeconds float64        `json:"after_seconds"` // since the job was launched
+	Reason       ExitReason     `json:"reason"`
+	Detail       string         `json:"detail,omitempty"`
+	ExitCode     int            `json:"exit_code"`
+	Signal       string         `json:"signal,omitempty"`
+	Errors       map[string]int `json:"errors,omitempty"` // known errors in the job output
+	Output       []string       `json:"output,omitempty"` // last lines of the job output
+	Stream       *OutputStats   `json:"stream,omitempty"` // output monitor statistics
+}
+
+// TemplateReport contains the results for a single job template
+type TemplateReport struct {
+	Name     string   `json:"name"`
+	Command  []string `json:"command"`
+	Weight   int      `json:"weight"`
+	Capacity int      `json:"capacity"` // jobs at the recommended capacity
+	Stalls   int      `json:"stalls"`   // stalled or killed jobs
+	Crashes  int      `json:"crashes"`  // jobs which crashed or exited on their own
+}
+
+// Report is the final result of a run.
+// Job counts and the capacity are in mix units, each consisting of weight jobs of every template.
+type Report struct {
+	Label           string            `json:"label,omitempty"` // name of the run for comparisons
+	Hostname        string            `json:"hostname"`
+	CPUModel        string            `json:"cpu_model,omitempty"`
+	Command         []string          `json:"command,omitempty"` // command of a single template run
+	Templates       []*TemplateReport `json:"templates"`
+	Cores           int               `json:"cores"`
+	Started         time.Time         `json:"started"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Strategy        string            `json:"strategy"`
+	Capacity        int               `json:"capacity"`
+	Unit            string            `json:"unit"` // jobs or units
+	Converged       bool              `json:"converged"`
+	JobCounts       []*ReportEntry    `json:"job_counts"`
+}
+
+// NewReport creates a report from the estimator results and the resource usage and stalls per job count
+func NewReport(templates []*Template, started time.Time, estimator *Estimator, resources map[int]*ResourceStats, exits map[int][]*ExitEvent) *Report {
+	hostname, _ := os.Hostname()
+	rep := &Report{
+		Hostname:        hostname,
+		CPUModel:        readCPUModel(),
+		Cores:           runtime.NumCPU(),
+		Started:         started,
+		DurationSeconds: time.Since(started).Seconds(),
+		Strategy:        estimator.Strategy().String(),
+		Capacity:        estimator.Capacity(),
+		Unit:            unitName(templates),
+	}
+	_, rep.Converged = estimator.Converged()
+	if len(templates) == 1 {
+		rep.Command = templates[0].Command()
+	}
+	for _, template := range templates {
+		tr := &TemplateReport{
+			Name:     template.Name,
+			Command:  template.Command(),
+			Weight:   template.Weight,
+			Capacity: rep.Capacity * template.Weight,
+		}
+		for _, events := range exits {
+			for _, event := range events {
+				if event.Template != template.Name {
+					continue
+				}
+				if event.Reason.CapacityFailure() {
+					tr.Stalls++
+				} else {
+					tr.Crashes++
+				}
+			}
+		}
+		rep.Templates = append(rep.Templates, tr)
+	}
+	for i, record := range estimator.Records() {
+		if record.Passes == 0 && record.Fails == 0 {
+			continue
+		}
+		rep.JobCounts = append(rep.JobCounts, &ReportEntry{
+			Jobs:          i + 1,
+			Passes:        record.Passes,
+			Fails:         record.Fails,
+			TestedSeconds: record.Tested.Seconds(),
+			Confidence:    record.Confidence,
+			Resources:     resources[i+1],
+			Exits:         exits[i+1],
+		})
+	}
+	return rep
+}
+
+// Print logs the report as a table
+func (rep *Report) Print() {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, rep.Unit+"\tpassed\tfailed\ttested\tconfidence\tcpu avg\tcpu max\tload\tmem max\tjob cpu\tjob rss\t")
+	for _, entry := range rep.JobCounts {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%v\t%0.1f\t", entry.Jobs, entry.Passes, entry.Fails,
+			seconds(entry.TestedSeconds), entry.Confidence)
+		if res := entry.Resources; res != nil {
+			fmt.Fprintf(w, "%0.1f%%\t%0.1f%%\t%0.2f\t%s\t%0.1f%%\t%s\t\n", res.CPUAvg, res.CPUMax, res.LoadAvg,
+				formatBytes(res.MemMax), res.JobCPU, formatBytes(res.JobRSS))
+		} else {
+			fmt.Fprintln(w, "\t\t\t\t\t\t")
+		}
+	}
+	w.Flush()
+	log.Printf("Results after %v (%s):\n%s", seconds(rep.DurationSeconds), rep.Strategy, b.String())
+	converged := "not converged"
+	if rep.Converged {
+		converged = "converged"
+	}
+	log.Printf("Recommended capacity: %d %s (%s)", rep.Capacity, rep.Unit, converged)
+	if rep.Unit == "units" {
+		for _, tr := range rep.Templates {
+			log.Printf("  %s: %d jobs, %d stalls, %d crashes", tr.Name, tr.Capacity, tr.Stalls, tr.Crashes)
+		}
+	}
+}
+
+// seconds converts seconds to a duration rounded to full seconds
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
+
+// Write writes the report as prefix.json, prefix.csv and prefix.html
+func (rep *Report) Write(prefix string) error {
+	if err := rep.writeJSON(prefix + ".json"); err != nil {
+		return err
+	}
+	if err := rep.writeCSV(prefix + ".csv"); err != nil {
+		return err
+	}
+	return rep.writeHTML(prefix + ".html")
+}
+
+func (rep *Report) writeJSON(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+func (rep *Report) writeCSV(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"jobs", "passes", "fails", "tested_seconds", "confidence",
+		"cpu_avg", "cpu_max", "load_avg", "mem_max", "job_cpu_avg", "job_rss_max", "power_avg"})
+	for _, entry := range rep.JobCounts {
+		row := []string{
+			strconv.Itoa(entry.Jobs),
+			strconv.Itoa(entry.Passes),
+			strconv.Itoa(entry.Fails),
+			strconv.FormatFloat(entry.TestedSeconds, 'f', 0, 64),
+			strconv.FormatFloat(entry.Confidence, 'f', 2, 64),
+		}
+		if res := entry.Resources; res != nil {
+			row = append(row,
+				strconv.FormatFloat(res.CPUAvg, 'f', 1, 64),
+				strconv.FormatFloat(res.CPUMax, 'f', 1, 64),
+				strconv.FormatFloat(res.LoadAvg, 'f', 2, 64),
+				strconv.FormatUint(res.MemMax, 10),
+				strconv.FormatFloat(res.JobCPU, 'f', 1, 64),
+				strconv.FormatUint(res.JobRSS, 10),
+				strconv.FormatFloat(res.PowerAvg, 'f', 1, 64),
+			)
+		} else {
+			row = append(row, "", "", "", "", "", "", "")
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes":    formatBytes,
+	"duration": seconds,
+	"join":     strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>transcoderload report {{.Hostname}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: right; }
+td:last-child { text-align: left; }
+tr.capacity { font-weight: bold; background: #dfd; }
+tr.fail { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>transcoderload report</h1>
+<p>
+{{with .Label}}Run: {{.}}<br>
+{{end}}Host: {{.Hostname}} ({{.Cores}} cores{{with .CPUModel}}, {{.}}{{end}})<br>
+Started: {{.Started.Format "2006-01-02 15:04:05"}}, duration: {{duration .DurationSeconds}}<br>
+Strategy: {{.Strategy}}
+</p>
+<h2>Recommended capacity: {{.Capacity}} {{.Unit}}</h2>
+<table>
+<tr><th>template</th><th>weight</th><th>jobs at capacity</th><th>stalls</th><th>crashes</th><th>command</th></tr>
+{{range .Templates}}<tr><td>{{.Name}}</td><td>{{.Weight}}</td><td>{{.Capacity}}</td><td>{{.Stalls}}</td><td>{{.Crashes}}</td><td><code>{{join .Command " "}}</code></td></tr>
+{{end}}</table>
+<p></p>
+<table>
+<tr><th>{{.Unit}}</th><th>passed</th><th>failed</th><th>tested</th><th>confidence</th><th>cpu avg</th><th>cpu max</th><th>load avg</th><th>mem max</th><th>job cpu</th><th>job rss</th><th>power</th><th>exits</th></tr>
+{{range .JobCounts}}<tr{{if eq .Jobs $.Capacity}} class="capacity"{{else if le .Confidence 0.0}} class="fail"{{end}}>
+<td>{{.Jobs}}</td><td>{{.Passes}}</td><td>{{.Fails}}</td><td>{{duration .TestedSeconds}}</td><td>{{printf "%0.1f" .Confidence}}</td>
+{{with .Resources}}<td>{{printf "%0.1f%%" .CPUAvg}}</td><td>{{printf "%0.1f%%" .CPUMax}}</td><td>{{printf "%0.2f" .LoadAvg}}</td><td>{{bytes .MemMax}}</td><td>{{printf "%0.1f%%" .JobCPU}}</td><td>{{bytes .JobRSS}}</td><td>{{if .PowerAvg}}{{printf "%0.1fW" .PowerAvg}}{{end}}</td>
+{{else}}<td></td><td></td><td></td><td></td><td></td><td></td><td></td>{{end}}
+<td>{{range .Exits}}{{.Job}} after {{duration .AfterSeconds}}: {{.Reason}}{{with .Detail}} ({{.}}){{end}}{{range $name, $count := .Errors}}, {{$name}} ({{$count}}){{end}}
+{{if .Output}}<details><summary>output</summary><pre>{{join .Output "\n"}}</pre></details>{{end}}<br>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (rep *Report) writeHTML(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return reportTemplate.Execute(f, rep)
+}
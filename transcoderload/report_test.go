@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewReport(t *testing.T) {
+	template := &Template{Name: "ffmpeg", Cmd: "ffmpeg", Args: []string{"-i", "in"}, Weight: 1}
+	e := NewEstimator(NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 0), defaultBaseHold)
+	// 1 job passes, 2 jobs stall, 1 job passes at the doubled hold time
+	e.Cycle()
+	e.Grow(defaultBaseHold)
+	e.Cycle()
+	e.Stall(5 * time.Second)
+	e.Cycle()
+	e.Grow(2 * defaultBaseHold)
+	resources := map[int]*ResourceStats{1: {Samples: 2, CPUAvg: 40.5, CPUMax: 50, LoadAvg: 1.25, MemMax: 1 << 30, JobCPU: 38.2, JobRSS: 200 << 20}}
+	exits := map[int][]*ExitEvent{2: {{Job: "ffmpeg-2", Template: "ffmpeg", Reason: ExitStall}}}
+
+	rep := NewReport([]*Template{template}, time.Now().Add(-time.Minute), e, resources, exits)
+	if rep.Capacity != 1 || rep.Unit != "jobs" || rep.Converged || rep.Strategy != "climb" {
+		t.Errorf("NewReport() got capacity %d %s, converged %v, strategy %v", rep.Capacity, rep.Unit, rep.Converged, rep.Strategy)
+	}
+	if len(rep.Templates) != 1 || rep.Templates[0].Capacity != 1 || rep.Templates[0].Stalls != 1 || rep.Templates[0].Crashes != 0 {
+		t.Errorf("NewReport() got templates %+v", rep.Templates[0])
+	}
+	expected := []*ReportEntry{
+		{Jobs: 1, Passes: 2, Fails: 0, TestedSeconds: 60, Confidence: 3, Resources: resources[1]},
+		{Jobs: 2, Passes: 0, Fails: 1, TestedSeconds: 5, Confidence: -1, Exits: exits[2]},
+	}
+	if !reflect.DeepEqual(rep.JobCounts, expected) {
+		t.Errorf("NewReport() got entries %+v, %+v", rep.JobCounts[0], rep.JobCounts[1])
+	}
+
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prefix := path.Join(dir, "run")
+	if err := rep.Write(prefix); err != nil {
+		t.Fatalf("Report.Write() error = %v", err)
+	}
+
+	f, err := os.Open(prefix + ".csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRows := [][]string{
+		{"jobs", "passes", "fails", "tested_seconds", "confidence", "cpu_avg", "cpu_max", "load_avg", "mem_max", "job_cpu_avg", "job_rss_max", "power_avg"},
+		{"1", "2", "0", "60", "3.00", "40.5", "50.0", "1.25", "1073741824", "38.2", "209715200", "0.0"},
+		{"2", "0", "1", "5", "-1.00", "", "", "", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("csv got = %v, expected %v", rows, expectedRows)
+	}
+
+	loaded, err := LoadReport(prefix + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Capacity != 1 || loaded.Unit != "jobs" || len(loaded.JobCounts) != 2 || loaded.JobCounts[1].Exits[0].Job != "ffmpeg-2" {
+		t.Errorf("json got = %+v", loaded)
+	}
+
+	html, err := ioutil.ReadFile(prefix + ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "Recommended capacity: 1 jobs") {
+		t.Errorf("html doesn't contain the capacity")
+	}
+}
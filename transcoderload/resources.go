@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the USER_HZ used for process times in /proc
+const clockTicks = 100
+
+// raplGlob matches the package domains of the RAPL energy counters
+const raplGlob = "/sys/class/powercap/intel-rapl:[0-9]*"
+
+// cpuTimes are the busy and total jiffies of a cpu from /proc/stat
+type cpuTimes struct {
+	busy  uint64
+	total uint64
+}
+
+// JobUsage is the resource usage of a job's process tree
+type JobUsage struct {
+	CPU float64 // percent of a single core
+	RSS uint64  // bytes
+}
+
+// ResourceSample is the system and job resource usage since the previous sample
+type ResourceSample struct {
+	CPU      float64   // percent of all cores
+	Cores    []float64 // percent per core
+	Load1    float64
+	MemUsed  uint64  // bytes
+	MemTotal uint64  // bytes
+	Power    float64 // watts of all cpu packages, 0 if unknown
+	Jobs     map[string]JobUsage
+}
+
+// ResourceSampler samples resource usage from /proc
+type ResourceSampler struct {
+	lastTime  time.Time
+	lastCPU   cpuTimes
+	lastCores []cpuTimes
+	lastJobs  map[int]uint64
+	lastPower map[string]uint64 // energy counters in microjoules
+}
+
+func NewResourceSampler() *ResourceSampler {
+	return &ResourceSampler{
+		lastJobs: make(map[int]uint64),
+	}
+}
+
+// Start takes the baseline for the next sample
+func (s *ResourceSampler) Start(jobs []*Job) {
+	s.Sample(jobs)
+}
+
+// Sample returns the resource usage since the last call
+func (s *ResourceSampler) Sample(jobs []*Job) (*ResourceSample, error) {
+	now := time.Now()
+	cpu, cores, err := readCPUTimes()
+	if err != nil {
+		return nil, err
+	}
+	sample := &ResourceSample{
+		CPU:   cpuPercent(s.lastCPU, cpu),
+		Cores: make([]float64, len(cores)),
+		Jobs:  make(map[string]JobUsage),
+	}
+	for i := range cores {
+		if i < len(s.lastCores) {
+			sample.Cores[i] = cpuPercent(s.lastCores[i], cores[i])
+		}
+	}
+	sample.Load1, err = readLoad()
+	if err != nil {
+		return nil, err
+	}
+	sample.MemUsed, sample.MemTotal, err = readMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	// process times by process group, each job runs in its own group
+	groups, err := readProcessGroups()
+	if err != nil {
+		return nil, err
+	}
+	elapsed := now.Sub(s.lastTime).Seconds()
+	lastJobs := make(map[int]uint64)
+	for _, job := range jobs {
+		pid := job.Pid()
+		if pid == 0 {
+			continue
+		}
+		usage := groups[pid]
+		lastJobs[pid] = usage.ticks
+		if last, ok := s.lastJobs[pid]; ok && elapsed > 0 && usage.ticks >= last {
+			sample.Jobs[job.Name] = JobUsage{
+				CPU: float64(usage.ticks-last) / clockTicks / elapsed * 100,
+				RSS: usage.rss,
+			}
+		}
+	}
+
+	energy := readEnergy()
+	for domain, uj := range energy {
+		last, ok := s.lastPower[domain]
+		if !ok || elapsed <= 0 {
+			continue
+		}
+		if uj < last {
+			// the counter wrapped around
+			uj += readUint(path.Join(domain, "max_energy_range_uj"))
+		}
+		sample.Power += float64(uj-last) / 1e6 / elapsed
+	}
+
+	s.lastTime, s.lastCPU, s.lastCores, s.lastJobs, s.lastPower = now, cpu, cores, lastJobs, energy
+	return sample, nil
+}
+
+func cpuPercent(last cpuTimes, now cpuTimes) float64 {
+	if now.total <= last.total || now.busy < last.busy {
+		return 0
+	}
+	return float64(now.busy-last.busy) / float64(now.total-last.total) * 100
+}
+
+// readCPUTimes reads the total and per core cpu times from /proc/stat
+func readCPUTimes() (total cpuTimes, cores []cpuTimes, err error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		var times cpuTimes
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return total, cores, fmt.Errorf("invalid /proc/stat line '%v'", scanner.Text())
+			}
+			// guest times are already included in user and nice
+			if i >= 8 {
+				break
+			}
+			times.total += value
+			// idle and iowait
+			if i != 3 && i != 4 {
+				times.busy += value
+			}
+		}
+		if fields[0] == "cpu" {
+			total = times
+		} else {
+			cores = append(cores, times)
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// readLoad reads the one minute load average
+func readLoad() (float64, error) {
+	content, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 1 {
+		return 0, fmt.Errorf("invalid /proc/loadavg '%v'", string(content))
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// readMemory reads the used and total memory from /proc/meminfo
+func readMemory() (used uint64, total uint64, err error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var available uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, err = strconv.ParseUint(fields[1], 10, 64)
+		case "MemAvailable:":
+			available, err = strconv.ParseUint(fields[1], 10, 64)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	// values are in kB
+	total *= 1024
+	available *= 1024
+	if available < total {
+		used = total - available
+	}
+	return
+}
+
+// readEnergy reads the RAPL energy counters of the cpu packages, which are usually only readable by root
+func readEnergy() map[string]uint64 {
+	energy := make(map[string]uint64)
+	domains, _ := filepath.Glob(raplGlob)
+	for _, domain := range domains {
+		// subdomains (core, uncore, dram) are part of the package
+		if strings.Count(path.Base(domain), ":") != 1 {
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join(domain, "energy_uj"))
+		if err != nil {
+			continue
+		}
+		uj, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil {
+			continue
+		}
+		energy[domain] = uj
+	}
+	return energy
+}
+
+// readUint reads a number from a sysfs file, 0 if it can't be read
+func readUint(filename string) uint64 {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	return value
+}
+
+// readCPUModel returns the model name of the first cpu, empty if unknown
+func readCPUModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		split := strings.SplitN(scanner.Text(), ":", 2)
+		if len(split) == 2 && strings.TrimSpace(split[0]) == "model name" {
+			return strings.TrimSpace(split[1])
+		}
+	}
+	return ""
+}
+
+type groupUsage struct {
+	ticks uint64
+	rss   uint64
+}
+
+// readProcessGroups sums up the cpu ticks and resident memory of all processes per process group
+func readProcessGroups() (map[int]groupUsage, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	pageSize := uint64(os.Getpagesize())
+	groups := make(map[int]groupUsage)
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			// process exited
+			continue
+		}
+		// the command name may contain spaces, skip past its closing parenthesis
+		stat := string(content)
+		end := strings.LastIndex(stat, ")")
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(stat[end+1:])
+		// fields start at state (3), pgrp is field 5, utime 14, stime 15, rss 24
+		if len(fields) < 22 {
+			continue
+		}
+		pgrp, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		rss, _ := strconv.ParseUint(fields[21], 10, 64)
+		usage := groups[pgrp]
+		usage.ticks += utime + stime
+		usage.rss += rss * pageSize
+		groups[pgrp] = usage
+	}
+	return groups, nil
+}
+
+// ResourceStats aggregates the resource samples taken at a single job count
+type ResourceStats struct {
+	Samples  int       `json:"samples"`
+	CPUAvg   float64   `json:"cpu_avg"`
+	CPUMax   float64   `json:"cpu_max"`
+	CoresAvg []float64 `json:"cores_avg"`
+	LoadAvg  float64   `json:"load_avg"`
+	LoadMax  float64   `json:"load_max"`
+	MemMax   uint64    `json:"mem_max"`
+	MemTotal uint64    `json:"mem_total"`
+	JobCPU   float64   `json:"job_cpu_avg"`         // average cpu percent of a single job
+	JobRSS   uint64    `json:"job_rss_max"`         // max resident memory of a single job
+	PowerAvg float64   `json:"power_avg,omitempty"` // watts of all cpu packages
+	PowerMax float64   `json:"power_max,omitempty"`
+
+	jobSamples   int
+	powerSamples int
+}
+
+// Add includes a sample into the running averages
+func (rs *ResourceStats) Add(sample *ResourceSample) {
+	n := float64(rs.Samples)
+	rs.Samples++
+	rs.CPUAvg = (rs.CPUAvg*n + sample.CPU) / (n + 1)
+	if sample.CPU > rs.CPUMax {
+		rs.CPUMax = sample.CPU
+	}
+	if len(rs.CoresAvg) != len(sample.Cores) {
+		rs.CoresAvg = make([]float64, len(sample.Cores))
+	}
+	for i, core := range sample.Cores {
+		rs.CoresAvg[i] = (rs.CoresAvg[i]*n + core) / (n + 1)
+	}
+	rs.LoadAvg = (rs.LoadAvg*n + sample.Load1) / (n + 1)
+	if sample.Load1 > rs.LoadMax {
+		rs.LoadMax = sample.Load1
+	}
+	if sample.MemUsed > rs.MemMax {
+		rs.MemMax = sample.MemUsed
+	}
+	rs.MemTotal = sample.MemTotal
+	if sample.Power > 0 {
+		m := float64(rs.powerSamples)
+		rs.powerSamples++
+		rs.PowerAvg = (rs.PowerAvg*m + sample.Power) / (m + 1)
+		if sample.Power > rs.PowerMax {
+			rs.PowerMax = sample.Power
+		}
+	}
+	if len(sample.Jobs) > 0 {
+		cpu := float64(0)
+		for _, usage := range sample.Jobs {
+			cpu += usage.CPU
+			if usage.RSS > rs.JobRSS {
+				rs.JobRSS = usage.RSS
+			}
+		}
+		m := float64(rs.jobSamples)
+		rs.jobSamples++
+		rs.JobCPU = (rs.JobCPU*m + cpu/float64(len(sample.Jobs))) / (m + 1)
+	}
+}
+
+// restoreCounters sets the sample counters of stats loaded from a saved run,
+// assuming that the job usage and power were known for all samples
+func (rs *ResourceStats) restoreCounters() {
+	if rs.JobCPU > 0 || rs.JobRSS > 0 {
+		rs.jobSamples = rs.Samples
+	}
+	if rs.PowerAvg > 0 {
+		rs.powerSamples = rs.Samples
+	}
+}
+
+// Print logs the aggregated resource usage
+func (rs *ResourceStats) Print(count int, unit string) {
+	cores := make([]string, len(rs.CoresAvg))
+	for i, core := range rs.CoresAvg {
+		cores[i] = strconv.Itoa(int(core + 0.5))
+	}
+	power := ""
+	if rs.PowerAvg > 0 {
+		power = fmt.Sprintf(", power avg %0.1fW, max %0.1fW", rs.PowerAvg, rs.PowerMax)
+	}
+	log.Printf("Resources at %d %s: cpu avg %0.1f%%, max %0.1f%%, cores [%s], load avg %0.2f, max %0.2f, mem max %s of %s, per job cpu %0.1f%%, rss %s%s",
+		count, unit, rs.CPUAvg, rs.CPUMax, strings.Join(cores, " "), rs.LoadAvg, rs.LoadMax,
+		formatBytes(rs.MemMax), formatBytes(rs.MemTotal), rs.JobCPU, formatBytes(rs.JobRSS), power)
+}
+
+// formatBytes formats a byte count in MiB
+func formatBytes(b uint64) string {
+	return fmt.Sprintf("%0.0fMiB", float64(b)/1048576)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"time"
+)
+
+// runStateVersion is increased on incompatible changes of the state file
+const runStateVersion = 1
+
+// RunTemplate identifies a job template in a saved run
+type RunTemplate struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	Weight  int      `json:"weight"`
+}
+
+// RunParameters are the options of the search which have to match to continue a saved run
+type RunParameters struct {
+	BaseHold      time.Duration `json:"base_hold"`
+	Growth        float64       `json:"growth"`
+	MaxHold       time.Duration `json:"max_hold"`
+	Confirmations int           `json:"confirmations"`
+}
+
+func newRunParameters(config *StrategyConfig) *RunParameters {
+	return &RunParameters{
+		BaseHold:      config.baseHold,
+		Growth:        config.growth,
+		MaxHold:       config.maxHold,
+		Confirmations: config.confirmations,
+	}
+}
+
+// check returns an error naming the first option which differs from the saved run
+func (p *RunParameters) check(config *StrategyConfig) error {
+	// the confidence weights and the climb hold time are relative to the base hold time
+	if p.BaseHold != config.baseHold {
+		return fmt.Errorf("saved run used -base-hold %v, got %v", p.BaseHold, config.baseHold)
+	}
+	if p.Growth != config.growth {
+		return fmt.Errorf("saved run used -hold-growth %v, got %v", p.Growth, config.growth)
+	}
+	if p.MaxHold != config.maxHold {
+		return fmt.Errorf("saved run used -max-hold %v, got %v", p.MaxHold, config.maxHold)
+	}
+	if p.Confirmations != config.confirmations {
+		return fmt.Errorf("saved run used -confirmations %d, got %d", p.Confirmations, config.confirmations)
+	}
+	return nil
+}
+
+// RunState is the progress of a run saved to continue it after an interruption
+type RunState struct {
+	Version        int                    `json:"version"`
+	Saved          time.Time              `json:"saved"`
+	Started        time.Time              `json:"started"`
+	ElapsedSeconds float64                `json:"elapsed_seconds"` // run time up to the save, without interruptions
+	Label          string                 `json:"label,omitempty"`
+	Hostname       string                 `json:"hostname"`
+	Templates      []*RunTemplate         `json:"templates"`
+	Strategy       string                 `json:"strategy"`
+	Parameters     *RunParameters         `json:"parameters"`
+	StrategyState  json.RawMessage        `json:"strategy_state"`
+	Records        []*Record              `json:"records"`
+	Resources      map[int]*ResourceStats `json:"resources,omitempty"`
+	Exits          map[int][]*ExitEvent   `json:"exits,omitempty"`
+	Launched       map[string]int         `json:"launched,omitempty"` // jobs launched per template, to continue the job names
+	Index          int                    `json:"index"`
+}
+
+// LoadRunState reads a saved run, the error satisfies os.IsNotExist if there is none
+func LoadRunState(filename string) (*RunState, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var state RunState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %v: %v", filename, err)
+	}
+	if state.Version != runStateVersion {
+		return nil, fmt.Errorf("state file %v has version %d, expected %d", filename, state.Version, runStateVersion)
+	}
+	return &state, nil
+}
+
+// Write saves the state, replacing the file only once it is written completely
+func (s *RunState) Write(filename string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(path.Dir(filename), path.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Apply checks that the run is continued with the same jobs and search options and restores the strategy
+func (s *RunState) Apply(templates []*Template, strategy Strategy, config *StrategyConfig) error {
+	if len(s.Templates) != len(templates) {
+		return fmt.Errorf("saved run has %d job templates, got %d", len(s.Templates), len(templates))
+	}
+	for i, template := range templates {
+		saved := s.Templates[i]
+		if saved.Name != template.Name || saved.Weight != template.Weight || !reflect.DeepEqual(saved.Command, template.Command()) {
+			return fmt.Errorf("job template %v differs from the saved run: %v", template.Name, saved.Command)
+		}
+	}
+	if s.Strategy != strategy.String() {
+		return fmt.Errorf("saved run used strategy %v, got %v", s.Strategy, strategy)
+	}
+	if s.Parameters == nil {
+		return fmt.Errorf("saved run has no search options")
+	}
+	if err := s.Parameters.check(config); err != nil {
+		return err
+	}
+	if err := strategy.Restore(s.StrategyState); err != nil {
+		return fmt.Errorf("saved run: %v", err)
+	}
+	return nil
+}
+
+// newRunState captures the progress of the runner
+func (r *Runner) newRunState() (*RunState, error) {
+	strategyState, err := json.Marshal(r.estimator.Strategy().State())
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	s := &RunState{
+		Version:        runStateVersion,
+		Saved:          time.Now(),
+		Started:        r.started,
+		ElapsedSeconds: r.elapsed().Seconds(),
+		Label:          r.config.label,
+		Hostname:       hostname,
+		Strategy:       r.estimator.Strategy().String(),
+		Parameters:     newRunParameters(r.config.search),
+		StrategyState:  strategyState,
+		Records:        r.estimator.Records(),
+		Resources:      r.resources,
+		Exits:          r.exits,
+		Launched:       make(map[string]int),
+		Index:          r.index,
+	}
+	for _, template := range r.config.templates {
+		s.Templates = append(s.Templates, &RunTemplate{
+			Name:    template.Name,
+			Command: template.Command(),
+			Weight:  template.Weight,
+		})
+		s.Launched[template.Name] = r.launched[template]
+	}
+	return s, nil
+}
+
+// saveState writes the progress to the state file if enabled, failures are only logged
+func (r *Runner) saveState() {
+	if r.config.stateFile == "" {
+		return
+	}
+	state, err := r.newRunState()
+	if err == nil {
+		err = state.Write(r.config.stateFile)
+	}
+	if err != nil {
+		log.Println("saving state failed:", err)
+	}
+}
+
+// restoreState continues the results and job numbering of a saved run,
+// the strategy has already been restored by Apply
+func (r *Runner) restoreState(s *RunState) {
+	r.estimator.Restore(s.Records)
+	for count, stats := range s.Resources {
+		stats.restoreCounters()
+		r.resources[count] = stats
+	}
+	for count, events := range s.Exits {
+		r.exits[count] = events
+	}
+	for _, template := range r.config.templates {
+		r.launched[template] = s.Launched[template.Name]
+	}
+	r.index = s.Index
+	r.started = s.Started
+	r.previous = time.Duration(s.ElapsedSeconds * float64(time.Second))
+	log.Printf("Resuming run started %v after %v, saved %v", s.Started.Format(time.RFC3339),
+		r.previous.Round(time.Second), s.Saved.Format(time.RFC3339))
+}
+
+// elapsed returns the run time including the runs before resuming
+func (r *Runner) elapsed() time.Duration {
+	return r.previous + time.Since(r.resumed)
+}
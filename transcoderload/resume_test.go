@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "run.state")
+
+	template := &Template{Name: "ffmpeg", Cmd: "ffmpeg", Args: []string{"-i", "{{.Input}}"}, Weight: 1}
+	if err := template.parse(); err != nil {
+		t.Fatal(err)
+	}
+	templates := []*Template{template}
+	search := &StrategyConfig{baseHold: defaultBaseHold, growth: defaultGrowth, maxHold: time.Minute, confirmations: 3}
+	r := &Runner{
+		estimator: NewEstimator(NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 3), defaultBaseHold),
+		config:    &RunnerConfig{templates: templates, search: search, stateFile: filename},
+		resources: map[int]*ResourceStats{1: {Samples: 4, CPUAvg: 20, JobCPU: 10}},
+		exits:     make(map[int][]*ExitEvent),
+		launched:  map[*Template]int{template: 2},
+		index:     2,
+		started:   time.Now().Add(-time.Hour),
+		resumed:   time.Now().Add(-time.Minute),
+		previous:  time.Minute,
+	}
+	r.estimator.Cycle()
+	r.estimator.Grow(defaultBaseHold)
+	r.estimator.Cycle()
+	r.estimator.Stall(time.Second)
+	r.saveState()
+
+	if _, err := LoadRunState(path.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("LoadRunState() of a missing file error = %v", err)
+	}
+	state, err := LoadRunState(filename)
+	if err != nil {
+		t.Fatalf("LoadRunState() error = %v", err)
+	}
+	if state.ElapsedSeconds < 120 || state.ElapsedSeconds > 130 {
+		t.Errorf("ElapsedSeconds got = %v, expected about 120", state.ElapsedSeconds)
+	}
+
+	other := &Template{Name: "ffmpeg", Cmd: "ffmpeg", Args: []string{"-i", "other"}, Weight: 1}
+	if err := other.parse(); err != nil {
+		t.Fatal(err)
+	}
+	modified := func(modify func(c *StrategyConfig)) *StrategyConfig {
+		c := *search
+		modify(&c)
+		return &c
+	}
+	tests := []struct {
+		name      string
+		templates []*Template
+		strategy  string
+		config    *StrategyConfig
+		expected  string
+	}{
+		{"same run", templates, "climb", search, ""},
+		{"other command", []*Template{other}, "climb", search, "job template ffmpeg differs"},
+		{"other strategy", templates, "binary", search, "saved run used strategy climb"},
+		{"other max hold", templates, "climb", modified(func(c *StrategyConfig) { c.maxHold = 2 * time.Minute }), "saved run used -max-hold 1m0s"},
+		{"other base hold", templates, "climb", modified(func(c *StrategyConfig) { c.baseHold = time.Minute }), "saved run used -base-hold 20s"},
+		{"other growth", templates, "climb", modified(func(c *StrategyConfig) { c.growth = 1.5 }), "saved run used -hold-growth 2"},
+		{"other confirmations", templates, "climb", modified(func(c *StrategyConfig) { c.confirmations = 2 }), "saved run used -confirmations 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := ParseStrategy(tt.strategy, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = state.Apply(tt.templates, strategy, tt.config)
+			if (tt.expected == "") != (err == nil) || (err != nil && !strings.HasPrefix(err.Error(), tt.expected)) {
+				t.Errorf("Apply() error = %v, expected %q", err, tt.expected)
+			}
+		})
+	}
+
+	restored := &Runner{
+		estimator: NewEstimator(NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 3), defaultBaseHold),
+		config:    &RunnerConfig{templates: templates},
+		resources: make(map[int]*ResourceStats),
+		exits:     make(map[int][]*ExitEvent),
+		launched:  make(map[*Template]int),
+	}
+	if err := state.Apply(templates, restored.estimator.Strategy(), search); err != nil {
+		t.Fatal(err)
+	}
+	restored.restoreState(state)
+	if count, hold := restored.estimator.Cycle(); count != 1 || hold != 2*defaultBaseHold {
+		t.Errorf("Cycle() after restoring got = %d, %v, expected 1, %v", count, hold, 2*defaultBaseHold)
+	}
+	records := restored.estimator.Records()
+	if len(records) != 2 || records[0].Passes != 1 || records[1].Fails != 1 || records[1].Tested != time.Second {
+		t.Errorf("restored records don't match")
+	}
+	if restored.launched[template] != 2 || restored.index != 2 || restored.resources[1].jobSamples != 4 {
+		t.Errorf("restored job numbering or resources don't match")
+	}
+	if !restored.started.Equal(r.started) {
+		t.Errorf("started got = %v, expected %v", restored.started, r.started)
+	}
+}
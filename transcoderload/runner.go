@@ -2,24 +2,79 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"strconv"
 	"sync"
 	"time"
 )
 
+// exitOutputLines is the number of output lines of an exited job included in the report
+const exitOutputLines = 10
+
+// maxCrashes is the number of crashed jobs replaced within a cycle before the run is aborted
+const maxCrashes = 3
+
+type RunnerConfig struct {
+	dirname        string
+	templates      []*Template
+	inputs         []string
+	source         *Source
+	isolation      *Isolation
+	logDir         string
+	stall          *StallConfig
+	progress       ProgressSource
+	monitor        *MonitorConfig
+	sampleInterval time.Duration
+	report         string
+	strategy       Strategy
+	search         *StrategyConfig // options the strategy was created with
+	stagger        time.Duration
+	budget         time.Duration
+	incremental    bool
+	preflight      time.Duration
+	label          string
+	stateFile      string
+	resume         *RunState
+}
+
 type Runner struct {
 	jobs      []*Job
 	estimator *Estimator
+	config    *RunnerConfig
+	sampler   *ResourceSampler
+	resources map[int]*ResourceStats
+	exits     map[int][]*ExitEvent
+	launched  map[*Template]int
+	reported  map[*Template]bool // templates with a job that reported its progress
+	index     int
+	err       error
+	mutex     sync.Mutex
+	state     *cycleState
+	started   time.Time     // start of the run, before any interruption
+	resumed   time.Time     // start of this process
+	previous  time.Duration // run time before resuming
 	done      sync.WaitGroup
+	finished  chan struct{}
 }
 
-func NewRunner(ctx context.Context, dirname string, cmd string) *Runner {
+func NewRunner(ctx context.Context, config *RunnerConfig) *Runner {
 	r := &Runner{
-		estimator: NewEstimator(),
+		estimator: NewEstimator(config.strategy, config.search.baseHold),
+		config:    config,
+		sampler:   NewResourceSampler(),
+		resources: make(map[int]*ResourceStats),
+		exits:     make(map[int][]*ExitEvent),
+		launched:  make(map[*Template]int),
+		reported:  make(map[*Template]bool),
+		finished:  make(chan struct{}),
+	}
+	r.started = time.Now()
+	r.resumed = r.started
+	if config.resume != nil {
+		r.restoreState(config.resume)
 	}
 	r.done.Add(1)
-	go r.run(ctx, dirname, cmd)
+	go r.run(ctx)
 	return r
 }
 
@@ -27,42 +82,290 @@ func (r *Runner) Wait() {
 	r.done.Wait()
 }
 
+// Finished is closed once the runner has stopped all jobs and written the report
+func (r *Runner) Finished() <-chan struct{} {
+	return r.finished
+}
+
+// Err returns why the run was aborted, nil if it ended regularly
+func (r *Runner) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+func (r *Runner) abort(err error) {
+	r.mutex.Lock()
+	r.err = err
+	r.mutex.Unlock()
+	r.stop()
+}
+
 func (r *Runner) stop() {
 	// exit
 	for i := 0; i < len(r.jobs); i++ {
 		r.jobs[i].Stop()
 	}
+	r.jobs = nil
+}
+
+// remove stops the job and removes it from the running jobs
+func (r *Runner) remove(job *Job) {
+	for i, j := range r.jobs {
+		if j == job {
+			j.Stop()
+			r.jobs = append(r.jobs[:i], r.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// resetDetectors lets the remaining jobs start over with their stall detection,
+// so the next cycle isn't judged by the overload
+func (r *Runner) resetDetectors() {
+	for _, job := range r.jobs {
+		job.ResetDetector()
+	}
+}
+
+// stopNewest stops the newest running job of the template
+func (r *Runner) stopNewest(template *Template) {
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		if r.jobs[i].Template == template {
+			r.jobs[i].Stop()
+			r.jobs = append(r.jobs[:i], r.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// countJobs returns the number of running jobs of the template
+func (r *Runner) countJobs(template *Template) int {
+	n := 0
+	for _, job := range r.jobs {
+		if job.Template == template {
+			n++
+		}
+	}
+	return n
+}
+
+// owns returns whether the job is one of the running jobs
+func (r *Runner) owns(job *Job) bool {
+	for _, j := range r.jobs {
+		if j == job {
+			return true
+		}
+	}
+	return false
+}
+
+// recordExit logs and records the exited job for the job count
+func (r *Runner) recordExit(count int, job *Job) *ExitEvent {
+	exit := job.Exit()
+	event := &ExitEvent{
+		Job:          job.Name,
+		Template:     job.Template.Name,
+		AfterSeconds: time.Since(job.Launched).Seconds(),
+		Reason:       exit.Reason,
+		Detail:       exit.Detail,
+		ExitCode:     exit.Code,
+		Signal:       exit.Signal,
+		Errors:       job.log.Errors(),
+		Output:       job.log.Tail(exitOutputLines),
+	}
+	if job.monitor != nil {
+		event.Stream = job.monitor.Stats()
+		logOutputStats(event.Job, event.Stream)
+	}
+	log.Printf("%s: %v %v after launch at %d %s", event.Job, exit, seconds(event.AfterSeconds), count, unitName(r.config.templates))
+	for _, name := range errorNames(event.Errors) {
+		log.Printf("%s: %s (%d times)", event.Job, name, event.Errors[name])
+	}
+	for _, line := range event.Output {
+		log.Printf("%s> %s", event.Job, line)
+	}
+	r.exits[count] = append(r.exits[count], event)
+	if job.Connected() {
+		r.reported[job.Template] = true
+	}
+	return event
+}
+
+// progressReported returns whether a job of the template reported its progress during the run,
+// which shows that the command line works if a later job exits without progress
+func (r *Runner) progressReported(template *Template) bool {
+	if r.reported[template] {
+		return true
+	}
+	for _, job := range r.jobs {
+		if job.Template == template && job.Connected() {
+			r.reported[template] = true
+			return true
+		}
+	}
+	return false
+}
+
+// launchJob launches a job of the template
+func (r *Runner) launchJob(ctx context.Context, template *Template, notify chan<- *Job) error {
+	r.launched[template]++
+	r.index++
+	name := jobName(template, r.launched[template])
+	vars := &JobVars{
+		Index:    r.index,
+		Name:     name,
+		Template: template.Name,
+	}
+	if len(r.config.inputs) > 0 {
+		vars.Input = r.config.inputs[(r.index-1)%len(r.config.inputs)]
+	}
+	job, err := r.startJob(ctx, vars, template, notify)
+	if err != nil {
+		return err
+	}
+	r.jobs = append(r.jobs, job)
+	log.Println(name, "launched")
+	return nil
+}
+
+// startJob launches a job, reading from its own stream of the test source if enabled
+func (r *Runner) startJob(ctx context.Context, vars *JobVars, template *Template, notify chan<- *Job) (*Job, error) {
+	var source *SourceStream
+	if r.config.source != nil {
+		var err error
+		source, err = r.config.source.Start()
+		if err != nil {
+			return nil, err
+		}
+		vars.Input = source.URL
+	}
+	job, err := launch(ctx, vars, template, source, r.config, notify, r.finished)
+	if err != nil {
+		if source != nil {
+			source.Stop()
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// sample records the current resource usage for the job count
+func (r *Runner) sample(count int, jobs []*Job) {
+	sample, err := r.sampler.Sample(jobs)
+	if err != nil {
+		log.Println("resource sampling failed:", err)
+		return
+	}
+	stats, ok := r.resources[count]
+	if !ok {
+		stats = &ResourceStats{}
+		r.resources[count] = stats
+	}
+	stats.Add(sample)
+}
+
+// printResources logs the resource usage for the job count
+func (r *Runner) printResources(count int) {
+	if stats, ok := r.resources[count]; ok {
+		stats.Print(count, unitName(r.config.templates))
+	}
+}
+
+// finish prints the final report and writes it to the report files
+func (r *Runner) finish() {
+	r.saveState()
+	rep := NewReport(r.config.templates, r.started, r.estimator, r.resources, r.exits)
+	rep.DurationSeconds = r.elapsed().Seconds()
+	rep.Label = r.config.label
+	rep.Print()
+	if r.config.report == "" {
+		return
+	}
+	if err := rep.Write(r.config.report); err != nil {
+		log.Println("report failed:", err)
+		return
+	}
+	log.Printf("Report written to %s.{json,csv,html}", r.config.report)
 }
 
-func (r *Runner) run(ctx context.Context, dirname string, cmd string) {
+func (r *Runner) run(ctx context.Context) {
 	defer r.done.Done()
-	defer r.estimator.PrintStats()
+	defer close(r.finished)
+	defer r.finish()
 
-	n := 1
 	timer := time.NewTimer(time.Second)
 	timer.Stop()
 	defer timer.Stop()
-	notify := make(chan struct{}, 1)
-	var jobs []*Job
+	ticker := time.NewTicker(r.config.sampleInterval)
+	defer ticker.Stop()
+	notify := make(chan *Job, 1)
+
+	// stop after the time budget even if the search didn't converge
+	var budget <-chan time.Time
+	if r.config.budget > 0 {
+		if r.previous >= r.config.budget {
+			log.Printf("Time budget of %v exhausted before resuming, capacity: %d %s", r.config.budget, r.estimator.Capacity(), unitName(r.config.templates))
+			return
+		}
+		budgetTimer := time.NewTimer(r.config.budget - r.previous)
+		defer budgetTimer.Stop()
+		budget = budgetTimer.C
+	}
+
+	if err := r.preflight(ctx); err != nil {
+		if ctx.Err() == nil {
+			r.abort(err)
+		}
+		return
+	}
+cycle:
 	for {
+		if capacity, ok := r.estimator.Converged(); ok {
+			log.Printf("Search (%v) converged, capacity: %d %s", r.estimator.Strategy(), capacity, unitName(r.config.templates))
+			r.stop()
+			return
+		}
+
 		count, holdTime := r.estimator.Cycle()
-		diff := count - len(jobs)
+		diff := count*unitSize(r.config.templates) - len(r.jobs)
 		log.Printf("count: %d, diff: %d, hold: %v", count, diff, holdTime)
 
-		for i := 0; i < diff; i++ {
-			// increase jobs
-			name := "ffmpeg" + strconv.Itoa(n)
-			n++
-			job, err := launch(ctx, name, dirname, cmd, notify)
-			if err != nil {
-				log.Fatal(err)
+		// scale each template to its share of the mix
+		missing := make(map[*Template]int)
+		pending := 0
+		for _, template := range r.config.templates {
+			templateDiff := count*template.Weight - r.countJobs(template)
+			for i := 0; i > templateDiff; i-- {
+				// decrease jobs, newest first
+				r.stopNewest(template)
+			}
+			if templateDiff > 0 {
+				missing[template] = templateDiff
+				pending += templateDiff
+			}
+		}
+		for pending > 0 {
+			// increase jobs, alternating between the templates
+			for _, template := range r.config.templates {
+				if missing[template] == 0 {
+					continue
+				}
+				missing[template]--
+				pending--
+				if err := r.launchJob(ctx, template, notify); err != nil {
+					r.abort(err)
+					return
+				}
+				r.publish(count, holdTime, time.Time{})
+				time.Sleep(r.config.stagger)
 			}
-			jobs = append(jobs, job)
-			log.Println("job launched")
-			time.Sleep(time.Second)
 		}
 
 		timer.Reset(holdTime)
+		cycleStart := time.Now()
+		r.sampler.Start(r.jobs)
+		r.publish(count, holdTime, cycleStart)
 
 		// Make sure we stop immediately when requested
 		select {
@@ -72,32 +375,74 @@ func (r *Runner) run(ctx context.Context, dirname string, cmd string) {
 		default:
 		}
 
-		select {
-		case <-ctx.Done():
-			r.stop()
-			return
-		case <-notify:
-			// job did stall
-			if !timer.Stop() {
-				<-timer.C
-			}
-			// stop all running jobs
-			for i := 0; i < len(jobs); i++ {
-				jobs[i].Stop()
-			}
-			jobs = []*Job{}
-			// drain notify
+		crashes := 0
+	hold:
+		for {
 			select {
-			case <-notify:
-			default:
+			case <-ctx.Done():
+				r.stop()
+				return
+			case <-budget:
+				log.Printf("Time budget of %v exhausted, capacity: %d %s", r.config.budget, r.estimator.Capacity(), unitName(r.config.templates))
+				r.stop()
+				return
+			case <-ticker.C:
+				r.sample(count, r.jobs)
+			case job := <-notify:
+				if !r.owns(job) {
+					continue
+				}
+				event := r.recordExit(count, job)
+				if event.Reason == ExitNoProgress && !r.progressReported(job.Template) {
+					r.abort(fmt.Errorf("%s: %s (%s), %s", job.Name, event.Reason, event.Detail, r.config.progress.Hint()))
+					return
+				}
+				if event.Reason == ExitStartFailed {
+					r.abort(fmt.Errorf("%s: %s (%s), check the command line", job.Name, event.Reason, event.Detail))
+					return
+				}
+				if !event.Reason.CapacityFailure() {
+					// not caused by the load, replace the job and keep holding
+					r.remove(job)
+					crashes++
+					if crashes > maxCrashes {
+						r.abort(fmt.Errorf("%d jobs crashed or exited within a cycle, check the command line and input", crashes))
+						return
+					}
+					if err := r.launchJob(ctx, job.Template, notify); err != nil {
+						r.abort(err)
+						return
+					}
+					r.publish(count, holdTime, cycleStart)
+					continue
+				}
+				// job did stall
+				if !timer.Stop() {
+					<-timer.C
+				}
+				if r.config.incremental {
+					// keep the other jobs running
+					r.remove(job)
+					r.resetDetectors()
+				} else {
+					// stop all running jobs
+					r.stop()
+				}
+				r.estimator.Stall(time.Since(cycleStart))
+				r.saveState()
+				r.publish(count, holdTime, time.Time{})
+				r.estimator.PrintStats()
+				r.printResources(count)
+				continue cycle
+			case <-timer.C:
+				// cycle ended without stall
+				break hold
 			}
-			r.estimator.Stall()
-			r.estimator.PrintStats()
-			continue
-		case <-timer.C:
-			// cycle ended without stall
 		}
-		r.estimator.Grow()
+		r.estimator.Grow(holdTime)
+		r.saveState()
+		r.publish(count, holdTime, time.Time{})
 		r.estimator.PrintStats()
+		r.printResources(count)
 	}
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// sourceDuration is the length of the generated test file in seconds, it is played in a loop
+	sourceDuration = 60
+	// sourceStartTimeout is how long a stream may take to bind its port
+	sourceStartTimeout = 5 * time.Second
+	// sourceStartAttempts is how often a stream is started on a new port if it fails to bind
+	sourceStartAttempts = 3
+)
+
+// SourceConfig configures the built-in live source
+type SourceConfig struct {
+	protocol string // tcp, udp, http or srt
+	file     string // file to serve, generated if empty
+	cmd      string // ffmpeg binary
+	size     string // resolution of the generated file
+	fps      int    // frame rate of the generated file
+}
+
+// Source serves a looping file as real-time paced MPEG-TS, one independent stream per job
+type Source struct {
+	config *SourceConfig
+	file   string
+}
+
+// SourceStream is the stream served to a single job
+type SourceStream struct {
+	URL    string
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+// NewSource checks the configuration and generates the test file if none was given
+func NewSource(config *SourceConfig, dirname string) (*Source, error) {
+	switch config.protocol {
+	case "tcp", "udp", "http", "srt":
+	default:
+		return nil, fmt.Errorf("unknown source protocol '%v'", config.protocol)
+	}
+	s := &Source{
+		config: config,
+		file:   config.file,
+	}
+	if s.file != "" {
+		if _, err := os.Stat(s.file); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	s.file = path.Join(dirname, "source.ts")
+	log.Printf("Generating %ds %s test source", sourceDuration, config.size)
+	cmd := exec.Command(config.cmd, "-v", "error", "-nostdin",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=%s:rate=%d", config.size, config.fps),
+		"-f", "lavfi", "-i", "sine=frequency=1000:sample_rate=48000",
+		"-t", strconv.Itoa(sourceDuration),
+		"-c:v", "libx264", "-preset", "veryfast", "-g", strconv.Itoa(2*config.fps), "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k",
+		"-f", "mpegts", s.file)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("generating test source failed: %v\n%s", err, output)
+	}
+	return s, nil
+}
+
+// Start starts serving a new stream.
+// It returns once the stream accepts connections, so the job doesn't fail to connect to its input.
+func (s *Source) Start() (*SourceStream, error) {
+	var err error
+	for attempt := 0; attempt < sourceStartAttempts; attempt++ {
+		var ss *SourceStream
+		ss, err = s.start()
+		if err == nil {
+			return ss, nil
+		}
+		if attempt+1 < sourceStartAttempts {
+			log.Printf("source: %v, retrying on a new port", err)
+		}
+	}
+	return nil, err
+}
+
+// start serves a stream on a free port and waits until it is bound
+func (s *Source) start() (*SourceStream, error) {
+	network := "tcp"
+	if s.config.protocol == "udp" || s.config.protocol == "srt" {
+		network = "udp"
+	}
+	port, err := freePort(network)
+	if err != nil {
+		return nil, err
+	}
+	output, input := sourceURLs(s.config.protocol, port)
+	args := []string{"-v", "error", "-nostdin", "-re", "-stream_loop", "-1", "-i", s.file, "-c", "copy", "-f", "mpegts"}
+	if s.config.protocol == "http" {
+		args = append(args, "-listen", "1")
+	}
+	args = append(args, output)
+
+	cmd := exec.Command(s.config.cmd, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: 0}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	ss := &SourceStream{
+		URL:    input,
+		cmd:    cmd,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		close(ss.exited)
+	}()
+	if s.config.protocol == "udp" {
+		// the job listens, the source only sends
+		return ss, nil
+	}
+	if err := ss.waitBound(network, port); err != nil {
+		ss.Stop()
+		return nil, err
+	}
+	return ss, nil
+}
+
+// waitBound waits until the source process has bound the port, tcp sockets have to be listening.
+// The port isn't dialed, as the listeners only serve a single client.
+func (ss *SourceStream) waitBound(network string, port int) error {
+	deadline := time.Now().Add(sourceStartTimeout)
+	for {
+		bound, err := processBound(ss.cmd.Process.Pid, network, port)
+		if err != nil {
+			return err
+		}
+		if bound {
+			return nil
+		}
+		select {
+		case <-ss.exited:
+			return fmt.Errorf("stream on port %d exited before accepting connections", port)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("stream on port %d not accepting connections after %v", port, sourceStartTimeout)
+		}
+	}
+}
+
+// Stop stops serving the stream
+func (ss *SourceStream) Stop() {
+	syscall.Kill(-ss.cmd.Process.Pid, syscall.SIGTERM)
+	<-ss.exited
+}
+
+// sourceURLs returns the url the source writes to and the url the job reads from
+func sourceURLs(protocol string, port int) (output string, input string) {
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	switch protocol {
+	case "tcp":
+		return "tcp://" + addr + "?listen=1", "tcp://" + addr
+	case "udp":
+		return "udp://" + addr + "?pkt_size=1316", "udp://" + addr
+	case "http":
+		return "http://" + addr + "/stream.ts", "http://" + addr + "/stream.ts"
+	case "srt":
+		return "srt://" + addr + "?mode=listener", "srt://" + addr + "?mode=caller"
+	}
+	return "", ""
+}
+
+// freePort returns a port which is currently unused on localhost
+func freePort(network string) (int, error) {
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// processBound returns whether one of the sockets of the process is bound to the port on localhost,
+// for tcp in the listening state. A socket of another process which took the port doesn't count.
+func processBound(pid int, network string, port int) (bool, error) {
+	fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		// the process has exited
+		return false, nil
+	}
+	inodes := make(map[string]bool)
+	for _, fd := range fds {
+		link, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%s", pid, fd.Name()))
+		if err == nil && strings.HasPrefix(link, "socket:[") {
+			inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = true
+		}
+	}
+	for _, table := range []string{network, network + "6"} {
+		f, err := os.Open("/proc/net/" + table)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return false, err
+		}
+		found := boundSocket(f, port, network == "tcp", inodes)
+		f.Close()
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// boundSocket scans a /proc/net socket table for one of the inodes bound to the port
+func boundSocket(f *os.File, port int, listening bool, inodes map[string]bool) bool {
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || !inodes[fields[9]] {
+			continue
+		}
+		i := strings.LastIndex(fields[1], ":")
+		if p, err := strconv.ParseInt(fields[1][i+1:], 16, 32); err != nil || int(p) != port {
+			continue
+		}
+		// state 0A is TCP_LISTEN
+		if !listening || fields[3] == "0A" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sourceURLs(t *testing.T) {
+	tests := []struct {
+		protocol string
+		output   string
+		input    string
+	}{
+		{"tcp", "tcp://127.0.0.1:9000?listen=1", "tcp://127.0.0.1:9000"},
+		{"udp", "udp://127.0.0.1:9000?pkt_size=1316", "udp://127.0.0.1:9000"},
+		{"http", "http://127.0.0.1:9000/stream.ts", "http://127.0.0.1:9000/stream.ts"},
+		{"srt", "srt://127.0.0.1:9000?mode=listener", "srt://127.0.0.1:9000?mode=caller"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			output, input := sourceURLs(tt.protocol, 9000)
+			if output != tt.output || input != tt.input {
+				t.Errorf("sourceURLs() got = %v, %v, expected %v, %v", output, input, tt.output, tt.input)
+			}
+		})
+	}
+}
+
+func Test_processBound(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tcpPort := ln.Addr().(*net.TCPAddr).Port
+	udpPort := conn.LocalAddr().(*net.UDPAddr).Port
+
+	tests := []struct {
+		name     string
+		pid      int
+		network  string
+		port     int
+		expected bool
+	}{
+		{"tcp listener", os.Getpid(), "tcp", tcpPort, true},
+		{"udp socket", os.Getpid(), "udp", udpPort, true},
+		{"other port", os.Getpid(), "tcp", udpPort, false},
+		{"other process", 1, "tcp", tcpPort, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound, err := processBound(tt.pid, tt.network, tt.port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bound != tt.expected {
+				t.Errorf("processBound() got = %v, expected %v", bound, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSource_Start(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not found")
+	}
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source, err := NewSource(&SourceConfig{protocol: "tcp", cmd: "ffmpeg", size: "320x180", fps: 25}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := source.Start()
+	if err != nil {
+		t.Fatalf("Source.Start() error = %v", err)
+	}
+	defer ss.Stop()
+
+	// the stream has to accept the job right away
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ss.URL, "tcp://"))
+	if err != nil {
+		t.Fatalf("dial %v error = %v", ss.URL, err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, tsPacketSize)
+	if _, err := io.ReadFull(conn, buf); err != nil || buf[0] != tsSyncByte {
+		t.Errorf("reading the stream got = %x, error %v", buf[:4], err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StallConfig configures when a job is considered stalled
+type StallConfig struct {
+	Window       int           // progress samples in the moving speed average
+	MinSpeed     float64       // moving average speed threshold
+	MaxDropRatio float64       // dropped and duplicated frames per encoded frame, 0 to disable
+	MaxLag       time.Duration // output time behind wall clock, 0 to disable
+	MinFPS       float64       // target frame rate, 0 to disable
+	Limit        int           // consecutive bad samples before the job is stalled
+	WarmUp       time.Duration // time after the launch in which the progress isn't checked
+}
+
+// StallDetector checks a stream of progress reports against the stall criteria
+type StallDetector struct {
+	config *StallConfig
+	speeds []float64
+	next   int
+	first  *Progress
+	start  time.Time
+	last   *Progress
+	lastAt time.Time
+	count  int
+}
+
+// NewStallDetector creates a stall detector for a single job
+func NewStallDetector(config *StallConfig) *StallDetector {
+	return &StallDetector{
+		config: config,
+	}
+}
+
+// Check adds a progress sample received at now.
+// It returns the reason once more than Limit consecutive samples violated the criteria.
+func (d *StallDetector) Check(p *Progress, now time.Time) string {
+	reasons := d.violations(p, now)
+	d.last, d.lastAt = p, now
+	if len(reasons) == 0 {
+		d.count = 0
+		return ""
+	}
+	d.count++
+	if d.count > d.config.Limit {
+		return strings.Join(reasons, ", ")
+	}
+	return ""
+}
+
+// violations returns the criteria violated by a sample
+func (d *StallDetector) violations(p *Progress, now time.Time) []string {
+	var reasons []string
+	if d.first == nil {
+		d.first, d.start = p, now
+	}
+
+	if !p.SpeedValid {
+		reasons = append(reasons, "speed N/A")
+	} else if avg, ok := d.averageSpeed(p.Speed); ok && avg < d.config.MinSpeed {
+		reasons = append(reasons, fmt.Sprintf("average speed %0.3fx below %0.2fx", avg, d.config.MinSpeed))
+	}
+
+	if d.last != nil {
+		frames := p.Frame - d.last.Frame
+		bad := p.DropFrames + p.DupFrames - d.last.DropFrames - d.last.DupFrames
+		if d.config.MaxDropRatio > 0 && frames > 0 {
+			if ratio := float64(bad) / float64(frames); ratio > d.config.MaxDropRatio {
+				reasons = append(reasons, fmt.Sprintf("%0.1f%% dropped/duplicated frames", ratio*100))
+			}
+		}
+		elapsed := now.Sub(d.lastAt).Seconds()
+		if d.config.MinFPS > 0 && elapsed > 0 {
+			if fps := float64(frames) / elapsed; fps < d.config.MinFPS {
+				reasons = append(reasons, fmt.Sprintf("fps %0.2f below %0.2f", fps, d.config.MinFPS))
+			}
+		}
+	}
+
+	if d.config.MaxLag > 0 {
+		output := time.Duration(p.OutTimeUs-d.first.OutTimeUs) * time.Microsecond
+		if lag := now.Sub(d.start) - output; lag > d.config.MaxLag {
+			reasons = append(reasons, fmt.Sprintf("output %v behind wall clock", lag.Round(time.Millisecond)))
+		}
+	}
+	return reasons
+}
+
+// averageSpeed adds a speed sample and returns the moving average once the window is full
+func (d *StallDetector) averageSpeed(speed float64) (float64, bool) {
+	window := d.config.Window
+	if window < 1 {
+		window = 1
+	}
+	if len(d.speeds) < window {
+		d.speeds = append(d.speeds, speed)
+	} else {
+		d.speeds[d.next] = speed
+		d.next = (d.next + 1) % window
+	}
+	if len(d.speeds) < window {
+		return 0, false
+	}
+	sum := float64(0)
+	for _, s := range d.speeds {
+		sum += s
+	}
+	return sum / float64(window), true
+}
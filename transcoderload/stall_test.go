@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStallDetector_Check(t *testing.T) {
+	config := &StallConfig{
+		Window:   3,
+		MinSpeed: 1,
+		MaxLag:   2 * time.Second,
+		Limit:    2,
+	}
+	d := NewStallDetector(config)
+	now := time.Now()
+
+	// a single slow sample is averaged out
+	speeds := []float64{1.1, 1.1, 0.9, 1.1, 1.1}
+	for i, speed := range speeds {
+		p := &Progress{Speed: speed, SpeedValid: true, OutTimeUs: int64(i) * 1000000}
+		if reason := d.Check(p, now.Add(time.Duration(i)*time.Second)); reason != "" {
+			t.Fatalf("StallDetector.Check() sample %d got = %v, expected no stall", i, reason)
+		}
+	}
+
+	// output stops advancing while speed is still reported
+	reason := ""
+	for i := 5; i < 10 && reason == ""; i++ {
+		p := &Progress{Speed: 1, SpeedValid: true, OutTimeUs: 4000000}
+		reason = d.Check(p, now.Add(time.Duration(i)*time.Second))
+	}
+	if reason != "output 5s behind wall clock" {
+		t.Errorf("StallDetector.Check() got = %v, expected lag stall", reason)
+	}
+}
@@ -1,61 +1,121 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"time"
 )
 
-type State int
-
-const (
-	Growing State = iota
-	Stalling
-	Stable
-)
+// Record summarizes the cycles run at a single job count
+type Record struct {
+	Passes     int           `json:"passes"`
+	Fails      int           `json:"fails"`
+	Tested     time.Duration `json:"tested"`
+	Confidence float64       `json:"confidence"`
+}
 
+// Estimator runs the cycles chosen by a strategy and keeps the results per job count
 type Estimator struct {
-	state   State
-	buckets []float64
-	index   int
-	weight  float64
+	strategy Strategy
+	records  []*Record
+	count    int
+	holdTime time.Duration
+	baseHold time.Duration
 }
 
-func NewEstimator() *Estimator {
+func NewEstimator(strategy Strategy, baseHold time.Duration) *Estimator {
 	return &Estimator{
-		weight:  1,
-		buckets: []float64{0},
+		strategy: strategy,
+		baseHold: baseHold,
 	}
 }
 
-func (e *Estimator) Stall() {
-	e.buckets[e.index] = e.buckets[e.index] - e.weight
-	if e.index < 1 {
-		e.index = 0
-	} else {
-		e.index = e.index - 1
+// record returns the record of the current job count
+func (e *Estimator) record() *Record {
+	for len(e.records) < e.count {
+		e.records = append(e.records, &Record{})
 	}
-	e.weight = e.weight * 2
+	return e.records[e.count-1]
 }
 
-func (e *Estimator) Grow() {
-	e.buckets[e.index] = e.buckets[e.index] + e.weight
-	e.state = Growing
-	e.index++
-	if e.index == len(e.buckets) {
-		e.buckets = append(e.buckets, 0)
-	}
+// weight is the confidence value of the current cycle, it increases linearly with the hold time
+func (e *Estimator) weight() float64 {
+	return float64(e.holdTime) / float64(e.baseHold)
 }
 
-func (e *Estimator) PrintStats() {
-	log.Println("Confidence per job count:", e.buckets)
+// Stall records a failed cycle which ran for tested
+func (e *Estimator) Stall(tested time.Duration) {
+	record := e.record()
+	record.Confidence -= e.weight()
+	record.Fails++
+	record.Tested += tested
+	e.strategy.Fail()
 }
 
-func (e *Estimator) State() State {
-	return e.state
+// Grow records a passed cycle which ran for tested
+func (e *Estimator) Grow(tested time.Duration) {
+	record := e.record()
+	record.Confidence += e.weight()
+	record.Passes++
+	record.Tested += tested
+	e.strategy.Pass()
 }
 
+func (e *Estimator) PrintStats() {
+	confidence := make([]string, 0, len(e.records))
+	for i, record := range e.records {
+		if record.Passes == 0 && record.Fails == 0 {
+			continue
+		}
+		confidence = append(confidence, fmt.Sprintf("%d: %g", i+1, record.Confidence))
+	}
+	log.Println("Confidence per job count:", strings.Join(confidence, ", "))
+}
+
+// Cycle returns the job count and hold time of the next cycle
 func (e *Estimator) Cycle() (count int, holdTime time.Duration) {
-	count = e.index + 1
-	holdTime = time.Duration(e.weight*20) * time.Second
-	return
+	e.count, e.holdTime = e.strategy.Next()
+	e.record()
+	return e.count, e.holdTime
+}
+
+// Converged returns the capacity once the strategy has finished
+func (e *Estimator) Converged() (capacity int, ok bool) {
+	return e.strategy.Done()
+}
+
+// Strategy returns the search strategy
+func (e *Estimator) Strategy() Strategy {
+	return e.strategy
+}
+
+// Restore continues with records saved by a previous run
+func (e *Estimator) Restore(records []*Record) {
+	e.records = records
+}
+
+// Records returns the records per job count, starting at one job
+func (e *Estimator) Records() []*Record {
+	return e.records
+}
+
+// Capacity returns the converged capacity, or otherwise the highest job count
+// up to which every tested job count has a positive confidence
+func (e *Estimator) Capacity() int {
+	if capacity, ok := e.Converged(); ok {
+		return capacity
+	}
+	capacity := 0
+	for i, record := range e.records {
+		if record.Passes == 0 && record.Fails == 0 {
+			// skipped by the strategy
+			continue
+		}
+		if record.Confidence <= 0 {
+			break
+		}
+		capacity = i + 1
+	}
+	return capacity
 }
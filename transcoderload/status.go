@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JobStatus is the live state of a single job
+type JobStatus struct {
+	Name           string       `json:"name"`
+	Template       string       `json:"template"`
+	Pid            int          `json:"pid"`
+	RunningSeconds float64      `json:"running_seconds"`
+	Speed          float64      `json:"speed"`
+	FPS            float64      `json:"fps"`
+	Frames         int64        `json:"frames"`
+	DroppedFrames  int64        `json:"dropped_frames"`
+	DupFrames      int64        `json:"duplicated_frames"`
+	Bitrate        float64      `json:"bitrate_kbits"`
+	Output         *OutputStats `json:"output,omitempty"` // output monitor statistics
+}
+
+// BucketStatus is the estimator state for a single job count
+type BucketStatus struct {
+	Jobs       int     `json:"jobs"`
+	Passes     int     `json:"passes"`
+	Fails      int     `json:"fails"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Status is the live state of a run
+type Status struct {
+	Strategy         string          `json:"strategy"`
+	Count            int             `json:"count"` // job count of the current cycle
+	Jobs             []*JobStatus    `json:"jobs"`
+	HoldSeconds      float64         `json:"hold_seconds"`
+	RemainingSeconds float64         `json:"remaining_seconds"`
+	Capacity         int             `json:"capacity"`
+	Converged        bool            `json:"converged"`
+	Buckets          []*BucketStatus `json:"buckets"`
+}
+
+// cycleState is the state of the runner published for the status endpoint
+type cycleState struct {
+	jobs       []*Job
+	count      int
+	holdTime   time.Duration
+	cycleStart time.Time
+	buckets    []*BucketStatus
+	capacity   int
+	converged  bool
+}
+
+// publish makes the current state available to Status
+func (r *Runner) publish(count int, holdTime time.Duration, cycleStart time.Time) {
+	state := &cycleState{
+		jobs:       append([]*Job(nil), r.jobs...),
+		count:      count,
+		holdTime:   holdTime,
+		cycleStart: cycleStart,
+		capacity:   r.estimator.Capacity(),
+	}
+	_, state.converged = r.estimator.Converged()
+	for i, record := range r.estimator.Records() {
+		state.buckets = append(state.buckets, &BucketStatus{
+			Jobs:       i + 1,
+			Passes:     record.Passes,
+			Fails:      record.Fails,
+			Confidence: record.Confidence,
+		})
+	}
+	r.mutex.Lock()
+	r.state = state
+	r.mutex.Unlock()
+}
+
+// Status returns the live state of the run
+func (r *Runner) Status() *Status {
+	r.mutex.Lock()
+	state := r.state
+	r.mutex.Unlock()
+
+	status := &Status{
+		Strategy: r.estimator.Strategy().String(),
+		Jobs:     []*JobStatus{},
+		Buckets:  []*BucketStatus{},
+	}
+	if state == nil {
+		return status
+	}
+	status.Count = state.count
+	status.HoldSeconds = state.holdTime.Seconds()
+	if remaining := state.holdTime - time.Since(state.cycleStart); remaining > 0 && !state.cycleStart.IsZero() {
+		status.RemainingSeconds = remaining.Seconds()
+	}
+	status.Capacity = state.capacity
+	status.Converged = state.converged
+	if state.buckets != nil {
+		status.Buckets = state.buckets
+	}
+	for _, job := range state.jobs {
+		if !job.Running() {
+			continue
+		}
+		js := &JobStatus{
+			Name:           job.Name,
+			Template:       job.Template.Name,
+			Pid:            job.Pid(),
+			RunningSeconds: time.Since(job.Launched).Seconds(),
+		}
+		if p := job.Progress(); p != nil {
+			js.Speed = p.Speed
+			js.FPS = p.FPS
+			js.Frames = p.Frame
+			js.DroppedFrames = p.DropFrames
+			js.DupFrames = p.DupFrames
+			js.Bitrate = p.Bitrate
+		}
+		if job.monitor != nil {
+			js.Output = job.monitor.Stats()
+		}
+		status.Jobs = append(status.Jobs, js)
+	}
+	return status
+}
+
+// StatusServer serves the live state of a run as JSON on /status and as Prometheus metrics on /metrics
+type StatusServer struct {
+	runner *Runner
+	ln     net.Listener
+}
+
+// NewStatusServer listens on addr and serves the runner status
+func NewStatusServer(addr string, runner *Runner) (*StatusServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatusServer{
+		runner: runner,
+		ln:     ln,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	go func() {
+		err := http.Serve(ln, mux)
+		if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Println("status server failed:", err)
+		}
+	}()
+	log.Println("Serving status on", ln.Addr())
+	return s, nil
+}
+
+func (s *StatusServer) Close() error {
+	return s.ln.Close()
+}
+
+func (s *StatusServer) handleStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.runner.Status())
+}
+
+func (s *StatusServer) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, s.runner.Status())
+}
+
+// writeMetrics writes the status in the Prometheus text exposition format
+func writeMetrics(w io.Writer, status *Status) {
+	var b strings.Builder
+	metric := func(name string, kind string, help string) {
+		fmt.Fprintf(&b, "# HELP transcoderload_%s %s\n# TYPE transcoderload_%s %s\n", name, help, name, kind)
+	}
+	boolValue := 0
+	if status.Converged {
+		boolValue = 1
+	}
+
+	metric("count", "gauge", "Job count of the current cycle.")
+	fmt.Fprintf(&b, "transcoderload_count %d\n", status.Count)
+	metric("jobs_running", "gauge", "Number of running jobs.")
+	fmt.Fprintf(&b, "transcoderload_jobs_running %d\n", len(status.Jobs))
+	metric("hold_seconds", "gauge", "Hold time of the current cycle.")
+	fmt.Fprintf(&b, "transcoderload_hold_seconds %g\n", status.HoldSeconds)
+	metric("hold_remaining_seconds", "gauge", "Time remaining in the current cycle.")
+	fmt.Fprintf(&b, "transcoderload_hold_remaining_seconds %g\n", status.RemainingSeconds)
+	metric("capacity", "gauge", "Capacity determined so far.")
+	fmt.Fprintf(&b, "transcoderload_capacity %d\n", status.Capacity)
+	metric("converged", "gauge", "Whether the search has converged.")
+	fmt.Fprintf(&b, "transcoderload_converged %d\n", boolValue)
+
+	metric("confidence", "gauge", "Confidence per job count.")
+	for _, bucket := range status.Buckets {
+		fmt.Fprintf(&b, "transcoderload_confidence{jobs=\"%d\"} %g\n", bucket.Jobs, bucket.Confidence)
+	}
+	metric("cycles_total", "counter", "Cycles per job count and result.")
+	for _, bucket := range status.Buckets {
+		fmt.Fprintf(&b, "transcoderload_cycles_total{jobs=\"%d\",result=\"pass\"} %d\n", bucket.Jobs, bucket.Passes)
+		fmt.Fprintf(&b, "transcoderload_cycles_total{jobs=\"%d\",result=\"fail\"} %d\n", bucket.Jobs, bucket.Fails)
+	}
+
+	jobMetric := func(name string, kind string, help string, value func(js *JobStatus) float64) {
+		metric(name, kind, help)
+		for _, js := range status.Jobs {
+			fmt.Fprintf(&b, "transcoderload_%s{job=%q,template=%q} %g\n", name, js.Name, js.Template, value(js))
+		}
+	}
+	jobMetric("job_speed", "gauge", "Realtime factor reported by the job.", func(js *JobStatus) float64 {
+		return js.Speed
+	})
+	jobMetric("job_fps", "gauge", "Frame rate reported by the job.", func(js *JobStatus) float64 {
+		return js.FPS
+	})
+	jobMetric("job_frames_total", "counter", "Frames encoded by the job.", func(js *JobStatus) float64 {
+		return float64(js.Frames)
+	})
+	jobMetric("job_dropped_frames_total", "counter", "Frames dropped by the job.", func(js *JobStatus) float64 {
+		return float64(js.DroppedFrames)
+	})
+	jobMetric("job_duplicated_frames_total", "counter", "Frames duplicated by the job.", func(js *JobStatus) float64 {
+		return float64(js.DupFrames)
+	})
+	jobMetric("job_running_seconds", "gauge", "Time since the job was launched.", func(js *JobStatus) float64 {
+		return js.RunningSeconds
+	})
+
+	monitored := false
+	for _, js := range status.Jobs {
+		monitored = monitored || js.Output != nil
+	}
+	outputMetric := func(name string, kind string, help string, value func(stats *OutputStats) float64) {
+		metric(name, kind, help)
+		for _, js := range status.Jobs {
+			if js.Output != nil {
+				fmt.Fprintf(&b, "transcoderload_%s{job=%q,template=%q} %g\n", name, js.Name, js.Template, value(js.Output))
+			}
+		}
+	}
+	if monitored {
+		outputMetric("job_output_bitrate_kbits", "gauge", "Bitrate of the job output.", func(stats *OutputStats) float64 {
+			return stats.Bitrate
+		})
+		outputMetric("job_output_delay_seconds", "gauge", "Growth of the job output delay versus the wall clock.", func(stats *OutputStats) float64 {
+			return stats.DelaySeconds
+		})
+		outputMetric("job_output_continuity_errors_total", "counter", "Continuity errors in the job output.", func(stats *OutputStats) float64 {
+			return float64(stats.ContinuityErrors)
+		})
+		outputMetric("job_output_timestamp_jumps_total", "counter", "Timestamp jumps in the job output.", func(stats *OutputStats) float64 {
+			return float64(stats.TimestampJumps)
+		})
+		outputMetric("job_output_keyframe_interval_seconds", "gauge", "Last keyframe interval of the job output.", func(stats *OutputStats) float64 {
+			return stats.KeyframeInterval
+		})
+	}
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	status := &Status{
+		Count:            2,
+		HoldSeconds:      40,
+		RemainingSeconds: 12.5,
+		Capacity:         1,
+		Jobs: []*JobStatus{
+			{Name: "ffmpeg-1", Template: "ffmpeg", Speed: 1.01, FPS: 25, Frames: 1000, DroppedFrames: 3},
+			{Name: "ffmpeg-2", Template: "ffmpeg", Speed: 0.98, FPS: 24.5, Frames: 250},
+		},
+		Buckets: []*BucketStatus{
+			{Jobs: 1, Passes: 2, Confidence: 3},
+			{Jobs: 2, Fails: 1, Confidence: -1},
+		},
+	}
+	var b strings.Builder
+	writeMetrics(&b, status)
+	expected := []string{
+		"transcoderload_count 2",
+		"transcoderload_jobs_running 2",
+		"transcoderload_hold_remaining_seconds 12.5",
+		"transcoderload_converged 0",
+		`transcoderload_confidence{jobs="2"} -1`,
+		`transcoderload_cycles_total{jobs="1",result="pass"} 2`,
+		`transcoderload_job_speed{job="ffmpeg-2",template="ffmpeg"} 0.98`,
+		`transcoderload_job_dropped_frames_total{job="ffmpeg-1",template="ffmpeg"} 3`,
+		"# TYPE transcoderload_job_frames_total counter",
+	}
+	lines := strings.Split(b.String(), "\n")
+	for _, line := range expected {
+		found := false
+		for _, l := range lines {
+			if l == line {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("writeMetrics() missing line %v", line)
+		}
+	}
+}
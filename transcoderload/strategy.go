@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultBaseHold is the default hold time of the first cycle
+	defaultBaseHold = 20 * time.Second
+	// defaultGrowth is the default factor by which the climb hold time grows with each stall
+	defaultGrowth = 2
+)
+
+// StrategyConfig configures the search strategies
+type StrategyConfig struct {
+	baseHold      time.Duration // hold time of the first cycle and of binary probe cycles
+	growth        float64       // climb: factor by which the hold time grows with each stall
+	maxHold       time.Duration
+	confirmations int
+	soakJobs      int
+	soakDuration  time.Duration
+}
+
+// Strategy decides which job count is tested next and when the search is done
+type Strategy interface {
+	// Next returns the job count and hold time of the next cycle
+	Next() (count int, holdTime time.Duration)
+	// Pass records that the cycle returned by Next ran stable
+	Pass()
+	// Fail records that a job stalled during the cycle returned by Next
+	Fail()
+	// Done returns the capacity once the strategy has finished
+	Done() (capacity int, ok bool)
+	// State returns the progress of the search for saving it
+	State() interface{}
+	// Restore continues the search from a state saved by a strategy with the same configuration
+	Restore(state json.RawMessage) error
+	String() string
+}
+
+// ParseStrategy creates the strategy by name
+func ParseStrategy(name string, config *StrategyConfig) (Strategy, error) {
+	if config.baseHold <= 0 {
+		return nil, fmt.Errorf("base hold time must be positive")
+	}
+	switch name {
+	case "climb":
+		if config.growth < 1 {
+			return nil, fmt.Errorf("hold time growth must be at least 1")
+		}
+		if config.maxHold <= 0 && config.confirmations > 0 {
+			// convergence counts the passes at the maximum hold time
+			return nil, fmt.Errorf("strategy climb with confirmations requires a maximum hold time")
+		}
+		return NewClimbStrategy(config.baseHold, config.growth, config.maxHold, config.confirmations), nil
+	case "binary":
+		if config.maxHold <= 0 {
+			return nil, fmt.Errorf("strategy binary requires a maximum hold time")
+		}
+		return NewBinaryStrategy(config.baseHold, config.maxHold), nil
+	case "soak":
+		if config.soakJobs < 1 || config.soakDuration <= 0 {
+			return nil, fmt.Errorf("strategy soak requires a job count and duration")
+		}
+		return NewSoakStrategy(config.soakJobs, config.soakDuration), nil
+	}
+	return nil, fmt.Errorf("unknown strategy '%v'", name)
+}
+
+// ClimbStrategy adds one job after each stable cycle and removes one after each stall.
+// The hold time grows with each stall, so the limit is tested more and more thoroughly.
+type ClimbStrategy struct {
+	baseHold      time.Duration
+	growth        float64
+	maxHold       time.Duration
+	confirmations int
+	index         int
+	weight        float64
+	passesAtMax   []int // passes at the maximum hold time per job count
+	fails         []int
+}
+
+func NewClimbStrategy(baseHold time.Duration, growth float64, maxHold time.Duration, confirmations int) *ClimbStrategy {
+	return &ClimbStrategy{
+		baseHold:      baseHold,
+		growth:        growth,
+		maxHold:       maxHold,
+		confirmations: confirmations,
+		weight:        1,
+		passesAtMax:   []int{0},
+		fails:         []int{0},
+	}
+}
+
+func (s *ClimbStrategy) Next() (count int, holdTime time.Duration) {
+	count = s.index + 1
+	holdTime = s.holdTime()
+	return
+}
+
+func (s *ClimbStrategy) holdTime() time.Duration {
+	holdTime := time.Duration(s.weight * float64(s.baseHold))
+	if s.maxHold > 0 && holdTime > s.maxHold {
+		holdTime = s.maxHold
+	}
+	return holdTime
+}
+
+func (s *ClimbStrategy) Pass() {
+	if s.maxHold > 0 && s.holdTime() >= s.maxHold {
+		s.passesAtMax[s.index]++
+	}
+	s.index++
+	if s.index == len(s.fails) {
+		s.passesAtMax = append(s.passesAtMax, 0)
+		s.fails = append(s.fails, 0)
+	}
+}
+
+func (s *ClimbStrategy) Fail() {
+	s.fails[s.index]++
+	if s.index > 0 {
+		s.index--
+	}
+	s.weight = s.weight * s.growth
+}
+
+// Done returns the capacity once a job count passed at the maximum hold time
+// and the next job count failed as often as required by the confirmations,
+// or 0 once a single job failed as often
+func (s *ClimbStrategy) Done() (capacity int, ok bool) {
+	k := s.confirmations
+	if k < 1 {
+		return 0, false
+	}
+	for i := 0; i+1 < len(s.fails); i++ {
+		if s.passesAtMax[i] >= k && s.fails[i+1] >= k {
+			return i + 1, true
+		}
+	}
+	// not even a single job runs stable, even if it passed a shorter cycle before
+	if s.fails[0] >= k {
+		return 0, true
+	}
+	return 0, false
+}
+
+type climbState struct {
+	Index       int     `json:"index"`
+	Weight      float64 `json:"weight"`
+	PassesAtMax []int   `json:"passes_at_max"`
+	Fails       []int   `json:"fails"`
+}
+
+func (s *ClimbStrategy) State() interface{} {
+	return &climbState{
+		Index:       s.index,
+		Weight:      s.weight,
+		PassesAtMax: s.passesAtMax,
+		Fails:       s.fails,
+	}
+}
+
+func (s *ClimbStrategy) Restore(data json.RawMessage) error {
+	var state climbState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if len(state.Fails) == 0 || len(state.PassesAtMax) != len(state.Fails) || state.Index < 0 ||
+		state.Index >= len(state.Fails) || state.Weight <= 0 {
+		return fmt.Errorf("invalid climb state")
+	}
+	s.index, s.weight, s.passesAtMax, s.fails = state.Index, state.Weight, state.PassesAtMax, state.Fails
+	return nil
+}
+
+func (s *ClimbStrategy) String() string {
+	return "climb"
+}
+
+// BinaryStrategy doubles the job count with short probe cycles until a stall occurs
+// and then bisects between the highest stable and the lowest stalled job count at the full hold time.
+type BinaryStrategy struct {
+	baseHold    time.Duration // hold time of the probe cycles
+	holdTime    time.Duration
+	lo          int  // highest stable job count
+	hi          int  // lowest stalled job count, 0 while unknown
+	loConfirmed bool // lo passed at the full hold time
+	count       int
+	probe       bool
+}
+
+func NewBinaryStrategy(baseHold time.Duration, holdTime time.Duration) *BinaryStrategy {
+	return &BinaryStrategy{
+		baseHold: baseHold,
+		holdTime: holdTime,
+	}
+}
+
+func (s *BinaryStrategy) Next() (count int, holdTime time.Duration) {
+	s.probe = false
+	switch {
+	case s.hi == 0:
+		// exponential phase
+		s.count = s.lo * 2
+		if s.count < 1 {
+			s.count = 1
+		}
+		s.probe = true
+	case s.hi-s.lo > 1:
+		s.count = (s.lo + s.hi) / 2
+	default:
+		// confirm the result found during the exponential phase
+		s.count = s.lo
+	}
+	if s.probe && s.baseHold < s.holdTime {
+		return s.count, s.baseHold
+	}
+	return s.count, s.holdTime
+}
+
+func (s *BinaryStrategy) Pass() {
+	s.lo = s.count
+	s.loConfirmed = !s.probe
+}
+
+func (s *BinaryStrategy) Fail() {
+	s.hi = s.count
+	if s.count <= s.lo {
+		// the probe result did not hold up, search again below
+		s.lo = 0
+		s.loConfirmed = false
+	}
+}
+
+func (s *BinaryStrategy) Done() (capacity int, ok bool) {
+	if s.hi > 0 && s.hi-s.lo <= 1 && (s.loConfirmed || s.lo == 0) {
+		return s.lo, true
+	}
+	return 0, false
+}
+
+type binaryState struct {
+	Lo          int  `json:"lo"`
+	Hi          int  `json:"hi"`
+	LoConfirmed bool `json:"lo_confirmed"`
+}
+
+func (s *BinaryStrategy) State() interface{} {
+	return &binaryState{
+		Lo:          s.lo,
+		Hi:          s.hi,
+		LoConfirmed: s.loConfirmed,
+	}
+}
+
+// Restore continues with the bounds found so far, an interrupted cycle is repeated by Next
+func (s *BinaryStrategy) Restore(data json.RawMessage) error {
+	var state binaryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Lo < 0 || (state.Hi != 0 && state.Hi <= state.Lo) {
+		return fmt.Errorf("invalid binary state")
+	}
+	s.lo, s.hi, s.loConfirmed = state.Lo, state.Hi, state.LoConfirmed
+	return nil
+}
+
+func (s *BinaryStrategy) String() string {
+	return "binary"
+}
+
+// SoakStrategy runs a fixed number of jobs for a fixed duration
+type SoakStrategy struct {
+	count    int
+	duration time.Duration
+	done     bool
+	stable   bool
+}
+
+func NewSoakStrategy(count int, duration time.Duration) *SoakStrategy {
+	return &SoakStrategy{
+		count:    count,
+		duration: duration,
+	}
+}
+
+func (s *SoakStrategy) Next() (count int, holdTime time.Duration) {
+	return s.count, s.duration
+}
+
+func (s *SoakStrategy) Pass() {
+	s.done, s.stable = true, true
+}
+
+func (s *SoakStrategy) Fail() {
+	s.done = true
+}
+
+// Done returns the job count if it ran stable and 0 otherwise
+func (s *SoakStrategy) Done() (capacity int, ok bool) {
+	if !s.done {
+		return 0, false
+	}
+	if s.stable {
+		return s.count, true
+	}
+	return 0, true
+}
+
+type soakState struct {
+	Done   bool `json:"done"`
+	Stable bool `json:"stable"`
+}
+
+func (s *SoakStrategy) State() interface{} {
+	return &soakState{
+		Done:   s.done,
+		Stable: s.stable,
+	}
+}
+
+// Restore only keeps a finished result, an interrupted soak starts over
+func (s *SoakStrategy) Restore(data json.RawMessage) error {
+	var state soakState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.done, s.stable = state.Done, state.Stable
+	return nil
+}
+
+func (s *SoakStrategy) String() string {
+	return fmt.Sprintf("soak %d jobs for %v", s.count, s.duration)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// search runs a strategy against a machine which handles capacity jobs
+func search(t *testing.T, strategy Strategy, capacity int, maxHold time.Duration) (int, int) {
+	e := NewEstimator(strategy, defaultBaseHold)
+	for cycles := 1; cycles <= 100; cycles++ {
+		count, hold := e.Cycle()
+		if maxHold > 0 && hold > maxHold {
+			t.Fatalf("%v: hold = %v, expected at most %v", strategy, hold, maxHold)
+		}
+		if count > capacity {
+			e.Stall(hold / 2)
+		} else {
+			e.Grow(hold)
+		}
+		if result, ok := e.Converged(); ok {
+			return result, cycles
+		}
+	}
+	t.Fatalf("%v: did not converge", strategy)
+	return 0, 0
+}
+
+func TestStrategies(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  func() Strategy
+		capacity  int
+		expected  int
+		maxCycles int
+	}{
+		{"climb", func() Strategy { return NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 2) }, 3, 3, 20},
+		{"climb no jobs", func() Strategy { return NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 2) }, 0, 0, 2},
+		{"binary", func() Strategy { return NewBinaryStrategy(defaultBaseHold, time.Minute) }, 3, 3, 5},
+		{"binary large", func() Strategy { return NewBinaryStrategy(defaultBaseHold, time.Minute) }, 45, 45, 14},
+		{"binary no jobs", func() Strategy { return NewBinaryStrategy(defaultBaseHold, time.Minute) }, 0, 0, 1},
+		{"soak stable", func() Strategy { return NewSoakStrategy(4, time.Hour) }, 5, 4, 1},
+		{"soak unstable", func() Strategy { return NewSoakStrategy(4, time.Hour) }, 3, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, cycles := search(t, tt.strategy(), tt.capacity, 0)
+			if result != tt.expected {
+				t.Errorf("capacity got = %d, expected %d", result, tt.expected)
+			}
+			if cycles > tt.maxCycles {
+				t.Errorf("cycles got = %d, expected at most %d", cycles, tt.maxCycles)
+			}
+		})
+	}
+}
+
+func TestClimbStrategy_MaxHold(t *testing.T) {
+	search(t, NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 3), 5, time.Minute)
+}
+
+func TestBinaryStrategy_ProbeFails(t *testing.T) {
+	s := NewBinaryStrategy(defaultBaseHold, time.Minute)
+	// 1, 2 and 4 pass the short probe cycles, 8 stalls
+	for _, expected := range []int{1, 2, 4, 8} {
+		count, hold := s.Next()
+		if count != expected || hold != defaultBaseHold {
+			t.Fatalf("BinaryStrategy.Next() got = %d, %v, expected %d, %v", count, hold, expected, defaultBaseHold)
+		}
+		if count < 8 {
+			s.Pass()
+		} else {
+			s.Fail()
+		}
+	}
+	// 6 and 5 stall at the full hold time, 4 has to be confirmed and fails as well
+	for _, expected := range []int{6, 5, 4} {
+		count, hold := s.Next()
+		if count != expected || hold != time.Minute {
+			t.Fatalf("BinaryStrategy.Next() got = %d, %v, expected %d, 1m", count, hold, expected)
+		}
+		s.Fail()
+	}
+	// search again below 4
+	if count, _ := s.Next(); count != 2 {
+		t.Errorf("BinaryStrategy.Next() got = %d, expected 2", count)
+	}
+}
+
+func TestEstimator_Capacity(t *testing.T) {
+	e := NewEstimator(NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 0), defaultBaseHold)
+	e.Cycle()
+	e.Grow(defaultBaseHold)
+	e.Cycle()
+	e.Stall(time.Second)
+	e.Cycle()
+	e.Grow(2 * defaultBaseHold)
+	if capacity := e.Capacity(); capacity != 1 {
+		t.Errorf("Estimator.Capacity() got = %d, expected 1", capacity)
+	}
+	records := e.Records()
+	if len(records) != 2 || records[0].Confidence != 3 || records[1].Confidence != -1 {
+		t.Errorf("Estimator.Records() got unexpected confidence")
+	}
+}
+
+func TestStrategy_Restore(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy func() Strategy
+	}{
+		{"climb", func() Strategy { return NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 2) }},
+		{"binary", func() Strategy { return NewBinaryStrategy(defaultBaseHold, time.Minute) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// interrupt the search after a few cycles against a capacity of 5
+			original := tt.strategy()
+			cycle := func(s Strategy) (int, time.Duration) {
+				count, hold := s.Next()
+				if count > 5 {
+					s.Fail()
+				} else {
+					s.Pass()
+				}
+				return count, hold
+			}
+			for i := 0; i < 6; i++ {
+				cycle(original)
+			}
+			state, err := json.Marshal(original.State())
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored := tt.strategy()
+			if err := restored.Restore(state); err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			for i := 0; i < 10; i++ {
+				count, hold := cycle(original)
+				restoredCount, restoredHold := cycle(restored)
+				if count != restoredCount || hold != restoredHold {
+					t.Fatalf("cycle %d got = %d, %v, expected %d, %v", i, restoredCount, restoredHold, count, hold)
+				}
+			}
+		})
+	}
+}
+
+func TestClimbStrategy_Growth(t *testing.T) {
+	s := NewClimbStrategy(10*time.Second, 1.5, time.Minute, 0)
+	// every stall at a single job grows the hold time by 1.5, until it is capped at the maximum hold time
+	for _, expected := range []time.Duration{10 * time.Second, 15 * time.Second, 22500 * time.Millisecond,
+		33750 * time.Millisecond, 50625 * time.Millisecond, time.Minute, time.Minute} {
+		if _, hold := s.Next(); hold != expected {
+			t.Fatalf("ClimbStrategy.Next() got = %v, expected %v", hold, expected)
+		}
+		s.Fail()
+	}
+}
+
+func TestClimbStrategy_DoneSingleJob(t *testing.T) {
+	// a single job passes a short cycle once and then fails at every longer one
+	s := NewClimbStrategy(defaultBaseHold, defaultGrowth, time.Minute, 2)
+	s.Next()
+	s.Pass()
+	s.Next()
+	s.Fail()
+	for i := 0; i < 2; i++ {
+		if count, _ := s.Next(); count != 1 {
+			t.Fatalf("ClimbStrategy.Next() got = %d, expected 1", count)
+		}
+		s.Fail()
+	}
+	if capacity, ok := s.Done(); !ok || capacity != 0 {
+		t.Errorf("ClimbStrategy.Done() got = %d, %v, expected 0, true", capacity, ok)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		config   StrategyConfig
+		wantErr  bool
+	}{
+		{"climb", "climb", StrategyConfig{baseHold: defaultBaseHold, growth: defaultGrowth, maxHold: time.Minute, confirmations: 3}, false},
+		{"climb without limit", "climb", StrategyConfig{baseHold: defaultBaseHold, growth: defaultGrowth}, false},
+		{"climb confirmations without limit", "climb", StrategyConfig{baseHold: defaultBaseHold, growth: defaultGrowth, confirmations: 3}, true},
+		{"climb shrinking hold", "climb", StrategyConfig{baseHold: defaultBaseHold, growth: 0.5, maxHold: time.Minute}, true},
+		{"binary without limit", "binary", StrategyConfig{baseHold: defaultBaseHold}, true},
+		{"soak", "soak", StrategyConfig{baseHold: defaultBaseHold, soakJobs: 2, soakDuration: time.Hour}, false},
+		{"unknown", "other", StrategyConfig{baseHold: defaultBaseHold}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseStrategy(tt.strategy, &tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("ParseStrategy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// validName matches template names, which are used in job names, file names and cgroup directories
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Template describes a kind of job in the mix
+type Template struct {
+	Name   string   `json:"name"`
+	Cmd    string   `json:"cmd"`
+	Args   []string `json:"args"`
+	Weight int      `json:"weight"` // jobs per mix unit
+
+	args []*template.Template
+}
+
+// JobVars are the placeholders available in the arguments of a template
+type JobVars struct {
+	Index    int    // number of the job within the run, starting at 1
+	Name     string // job name
+	Template string // template name
+	TmpDir   string // temporary directory of the job, removed when the job ends
+	Input    string // input assigned round-robin from -inputs
+	Output   string // sink of the output monitor
+}
+
+// parse compiles the placeholders in the arguments
+func (t *Template) parse() error {
+	t.args = make([]*template.Template, len(t.Args))
+	for i, arg := range t.Args {
+		tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return fmt.Errorf("template '%v': %v", t.Name, err)
+		}
+		t.args[i] = tmpl
+	}
+	// catch unknown placeholders before the first job is launched
+	_, err := t.Expand(&JobVars{Index: 1, Name: jobName(t, 1), Template: t.Name})
+	return err
+}
+
+// Expand returns the arguments with the placeholders replaced for a single job
+func (t *Template) Expand(vars *JobVars) ([]string, error) {
+	if t.args == nil {
+		if err := t.parse(); err != nil {
+			return nil, err
+		}
+	}
+	args := make([]string, len(t.args))
+	for i, tmpl := range t.args {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, vars); err != nil {
+			return nil, fmt.Errorf("template '%v': %v", t.Name, err)
+		}
+		args[i] = b.String()
+	}
+	return args, nil
+}
+
+// UsesInput reports whether the arguments depend on the input of the job
+func (t *Template) UsesInput() bool {
+	vars := &JobVars{Index: 1, Name: jobName(t, 1), Template: t.Name}
+	without, err := t.Expand(vars)
+	if err != nil {
+		return false
+	}
+	vars.Input = "input"
+	with, err := t.Expand(vars)
+	if err != nil {
+		return false
+	}
+	return !reflect.DeepEqual(without, with)
+}
+
+// jobName returns the name of the nth job of the template
+func jobName(t *Template, n int) string {
+	return t.Name + "-" + strconv.Itoa(n)
+}
+
+// Command returns the command line of the template
+func (t *Template) Command() []string {
+	return append([]string{t.Cmd}, t.Args...)
+}
+
+// JobDefinition is the content of a job definition file
+type JobDefinition struct {
+	Templates []*Template `json:"templates"`
+}
+
+// LoadTemplates reads the job templates from a job definition file.
+// Templates without a command run defaultCmd.
+func LoadTemplates(filename string, defaultCmd string) ([]*Template, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var def JobDefinition
+	if err := json.Unmarshal(content, &def); err != nil {
+		return nil, fmt.Errorf("invalid job definition %v: %v", filename, err)
+	}
+	if len(def.Templates) == 0 {
+		return nil, fmt.Errorf("job definition %v contains no templates", filename)
+	}
+	names := make(map[string]bool)
+	for i, t := range def.Templates {
+		if t.Name == "" {
+			return nil, fmt.Errorf("job definition %v: template %d has no name", filename, i+1)
+		}
+		if !validName.MatchString(t.Name) {
+			return nil, fmt.Errorf("job definition %v: template name '%v' may only contain letters, digits, '_' and '-'", filename, t.Name)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("job definition %v: duplicate template '%v'", filename, t.Name)
+		}
+		names[t.Name] = true
+		if t.Cmd == "" {
+			t.Cmd = defaultCmd
+		}
+		if t.Weight == 0 {
+			t.Weight = 1
+		}
+		if t.Weight < 0 {
+			return nil, fmt.Errorf("job definition %v: template '%v' has a negative weight", filename, t.Name)
+		}
+		if err := t.parse(); err != nil {
+			return nil, fmt.Errorf("job definition %v: %v", filename, err)
+		}
+	}
+	return def.Templates, nil
+}
+
+// ParseInputs splits a comma separated input list
+func ParseInputs(arg string) []string {
+	var inputs []string
+	for _, input := range strings.Split(arg, ",") {
+		if input = strings.TrimSpace(input); input != "" {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// unitSize returns the number of jobs in a single mix unit
+func unitSize(templates []*Template) int {
+	size := 0
+	for _, t := range templates {
+		size += t.Weight
+	}
+	return size
+}
+
+// unitName returns how job counts are measured, mix units unless a single template runs one job per unit
+func unitName(templates []*Template) string {
+	if len(templates) == 1 && templates[0].Weight == 1 {
+		return "jobs"
+	}
+	return "units"
+}
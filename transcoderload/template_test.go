@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		content  string
+		wantErr  bool
+		expected []Template
+	}{
+		{"mix", `{"templates": [
+			{"name": "hd", "args": ["-i", "in", "-s", "1920x1080"], "weight": 2},
+			{"name": "audio", "cmd": "/opt/audio.sh", "args": ["in"]}
+		]}`, false, []Template{
+			{Name: "hd", Cmd: "ffmpeg", Args: []string{"-i", "in", "-s", "1920x1080"}, Weight: 2},
+			{Name: "audio", Cmd: "/opt/audio.sh", Args: []string{"in"}, Weight: 1},
+		}},
+		{"name characters", `{"templates": [{"name": "x264_hd-2"}]}`, false, []Template{
+			{Name: "x264_hd-2", Cmd: "ffmpeg", Weight: 1},
+		}},
+		{"empty", `{"templates": []}`, true, nil},
+		{"no name", `{"templates": [{"args": ["-i", "in"]}]}`, true, nil},
+		{"duplicate", `{"templates": [{"name": "hd"}, {"name": "hd"}]}`, true, nil},
+		{"name with slash", `{"templates": [{"name": "../hd"}]}`, true, nil},
+		{"name with space", `{"templates": [{"name": "hd 1"}]}`, true, nil},
+		{"negative weight", `{"templates": [{"name": "hd", "weight": -1}]}`, true, nil},
+		{"invalid", `{"templates": [`, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := path.Join(dir, "jobs.json")
+			if err := ioutil.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			templates, err := LoadTemplates(filename, "ffmpeg")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadTemplates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(templates) != len(tt.expected) {
+				t.Fatalf("LoadTemplates() got %d templates, expected %d", len(templates), len(tt.expected))
+			}
+			for i, template := range templates {
+				expected := tt.expected[i]
+				if template.Name != expected.Name || template.Cmd != expected.Cmd || template.Weight != expected.Weight ||
+					len(template.Args) != len(expected.Args) {
+					t.Errorf("LoadTemplates() template %d got = %+v, expected %+v", i, template, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplate_Expand(t *testing.T) {
+	template := &Template{
+		Name: "hd",
+		Args: []string{"-i", "{{.Input}}", "-f", "mpegts", "{{.TmpDir}}/{{.Name}}.ts", "-metadata", "title=job {{.Index}}"},
+	}
+	args, err := template.Expand(&JobVars{Index: 3, Name: "hd2", Template: "hd", TmpDir: "/tmp/x/hd2", Input: "srt://source:9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-i", "srt://source:9000", "-f", "mpegts", "/tmp/x/hd2/hd2.ts", "-metadata", "title=job 3"}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("Template.Expand() arg %d got = %v, expected %v", i, args[i], expected[i])
+		}
+	}
+
+	invalid := &Template{Name: "sd", Args: []string{"{{.Bitrate}}"}}
+	if err := invalid.parse(); err == nil {
+		t.Errorf("Template.parse() expected error for unknown placeholder")
+	}
+}
+
+func TestTemplate_UsesInput(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"-i", "{{.Input}}", "-f", "null", "-"}, true},
+		{[]string{"-i", "srt://host:9000?streamid={{.Input}}"}, true},
+		{[]string{"-i", "test.ts", "{{.TmpDir}}/{{.Name}}.ts"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		template := &Template{Name: "hd", Args: tt.args}
+		if got := template.UsesInput(); got != tt.expected {
+			t.Errorf("Template.UsesInput() of %v got = %v, expected %v", tt.args, got, tt.expected)
+		}
+	}
+}